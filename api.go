@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix      = "/api/"
+	feedPrefix     = "/feed/"
+	feedExt        = ".ics"
+	feedTokenBytes = 16
+	icsTimeFormat  = "20060102T150405Z"
+	apiMaxBody     = 1 << 20
+)
+
+type (
+	apiUser struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		Reminders int    `json:"reminders"`
+		Tasks     int    `json:"tasks"`
+	}
+
+	apiItem struct {
+		ID      int      `json:"id"`
+		Name    string   `json:"name"`
+		Kind    string   `json:"kind"`
+		Due     string   `json:"due,omitempty"`
+		Done    bool     `json:"done"`
+		Missed  bool     `json:"missed,omitempty"`
+		Channel string   `json:"channel,omitempty"`
+		List    string   `json:"list,omitempty"`
+		Tags    []string `json:"tags,omitempty"`
+
+		Priority int       `json:"priority,omitempty"`
+		Subtasks []apiItem `json:"subtasks,omitempty"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+
+	// Response written on the state goroutine,
+	// and sent to the client once the action is done
+	apiResponse struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
+
+var itemKindString = map[itemKind]string{
+	itemReminder: "reminder",
+	itemTask:     "task",
+}
+
+func makeAPIItem(it *item) apiItem {
+	result := apiItem{
+		ID:      it.id,
+		Name:    it.name,
+		Kind:    itemKindString[it.kind],
+		Done:    it.done,
+		Missed:  it.missed,
+		Channel: it.channelID,
+		List:    it.list,
+		Tags:    it.tags,
+
+		Priority: int(it.priority),
+	}
+	for i := range it.subtasks {
+		result.Subtasks = append(result.Subtasks, makeAPIItem(&it.subtasks[i]))
+	}
+	if it.hasDueDate {
+		result.Due = it.dueTime.Format(time.RFC3339)
+	}
+	return result
+}
+
+func (a *app) startAPI() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix, a.serveAPI)
+	mux.HandleFunc(feedPrefix, a.serveFeed)
+
+	a.api = &http.Server{
+		Addr:    a.config.API.Address,
+		Handler: mux,
+	}
+	go func() {
+		err := a.api.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("API server failure: ", err)
+		}
+	}()
+}
+
+// Routes:
+//
+//	GET    /api/users
+//	GET    /api/users/<discord id>/items
+//	POST   /api/users/<discord id>/items
+//	POST   /api/users/<discord id>/items/<item id>/complete
+//	DELETE /api/users/<discord id>/items/<item id>
+//	POST   /api/users/<discord id>/feed
+//
+// Every route needs the API.Token of the config as a bearer token
+func (a *app) serveAPI(w http.ResponseWriter, r *http.Request) {
+	// Read and written off the state goroutine, a slow client
+	// never holds up the other users
+	body, err := io.ReadAll(io.LimitReader(r.Body, apiMaxBody))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response := &apiResponse{header: make(http.Header)}
+	a.do(func() { a.handleAPI(response, r, body) })
+	response.send(w)
+}
+
+// Must run on the state goroutine
+func (a *app) handleAPI(w http.ResponseWriter, r *http.Request, body []byte) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	if path[0] != "users" {
+		writeAPIError(w, http.StatusNotFound, "unknown route")
+		return
+	}
+
+	if len(path) == 1 {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		users := make([]apiUser, 0, len(a.users))
+		for _, u := range a.users {
+			users = append(users, apiUser{
+				ID:        u.id,
+				Name:      u.name,
+				Reminders: len(u.reminders),
+				Tasks:     len(u.tasks),
+			})
+		}
+		writeAPIResponse(w, http.StatusOK, users)
+		return
+	}
+
+	u, exist := a.users[path[1]]
+	if !exist {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no user with id %s", path[1]))
+		return
+	}
+
+	switch {
+	case len(path) == 3 && path[2] == "items" && r.Method == http.MethodGet:
+		items := make([]apiItem, 0, len(u.reminders)+len(u.tasks))
+		for i := range u.reminders {
+			items = append(items, makeAPIItem(&u.reminders[i]))
+		}
+		for i := range u.tasks {
+			items = append(items, makeAPIItem(&u.tasks[i]))
+		}
+		writeAPIResponse(w, http.StatusOK, items)
+
+	case len(path) == 3 && path[2] == "items" && r.Method == http.MethodPost:
+		var entry importEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cmd, err := entry.command()
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx := a.newContext(nil)
+		if required := a.missingCapability(ctx, cmd, u.id); required != "" {
+			writeAPIError(w, http.StatusForbidden, permissionDenied(cmd, required).description)
+			return
+		}
+		result, it := a.executeCommand(ctx, u, cmd)
+		if result.status == statusError {
+			// The command of a valid entry only fails when its item cannot be stored
+			writeAPIError(w, http.StatusInternalServerError, result.description)
+			return
+		}
+		writeAPIResponse(w, http.StatusCreated, makeAPIItem(it))
+
+	case len(path) == 5 && path[2] == "items" && path[4] == "complete" && r.Method == http.MethodPost:
+		id, err := strconv.Atoi(path[3])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid item id")
+			return
+		}
+		it, found := a.completeItem(u, id)
+		if !found {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no item with id %d", id))
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, makeAPIItem(&it))
+
+	case len(path) == 4 && path[2] == "items" && r.Method == http.MethodDelete:
+		id, err := strconv.Atoi(path[3])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid item id")
+			return
+		}
+		owner, removed := a.itemOwner(id)
+		if owner != u {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no item with id %d", id))
+			return
+		}
+		before := snapshotItem(u, removed)
+		a.deleteItem(u, id)
+		a.recordChange(u.id, changeDeleted, before, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(path) == 3 && path[2] == "feed" && r.Method == http.MethodPost:
+		token, err := a.resetFeedToken(u)
+		if err != nil {
+			log.Println("DB access failure: ", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not create the feed token")
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, map[string]string{
+			"url": feedPrefix + token + feedExt,
+		})
+
+	default:
+		writeAPIError(w, http.StatusNotFound, "unknown route")
+	}
+}
+
+// The request carries the configured token as a bearer token.
+// Must run on the state goroutine
+func (a *app) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if a.config.API.Token == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.config.API.Token)) == 1
+}
+
+func (a *app) serveFeed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, feedPrefix)
+	if r.Method != http.MethodGet || !strings.HasSuffix(token, feedExt) {
+		http.NotFound(w, r)
+		return
+	}
+	token = strings.TrimSuffix(token, feedExt)
+
+	var feed string
+	a.do(func() {
+		for _, u := range a.users {
+			if u.feedToken != "" && subtle.ConstantTimeCompare([]byte(u.feedToken), []byte(token)) == 1 {
+				feed = makeICSFeed(u, a.clock.now())
+				return
+			}
+		}
+	})
+	if feed == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(feed))
+}
+
+func makeICSFeed(u *user, now time.Time) string {
+	b := strings.Builder{}
+	stamp := now.UTC().Format(icsTimeFormat)
+
+	b.WriteString("BEGIN:VCALENDAR\r\n")
+	b.WriteString("VERSION:2.0\r\n")
+	b.WriteString("PRODID:-//remindMeBot//EN\r\n")
+	fmt.Fprintf(&b, "X-WR-CALNAME:%s\r\n", escapeICSText(u.name))
+	for _, reminder := range u.reminders {
+		b.WriteString("BEGIN:VEVENT\r\n")
+		fmt.Fprintf(&b, "UID:%d@remindmebot\r\n", reminder.id)
+		fmt.Fprintf(&b, "DTSTAMP:%s\r\n", stamp)
+		fmt.Fprintf(&b, "DTSTART:%s\r\n", reminder.dueTime.UTC().Format(icsTimeFormat))
+		fmt.Fprintf(&b, "SUMMARY:%s\r\n", escapeICSText(reminder.name))
+		b.WriteString("END:VEVENT\r\n")
+	}
+	for _, task := range u.tasks {
+		b.WriteString("BEGIN:VTODO\r\n")
+		fmt.Fprintf(&b, "UID:%d@remindmebot\r\n", task.id)
+		fmt.Fprintf(&b, "DTSTAMP:%s\r\n", stamp)
+		if task.hasDueDate {
+			fmt.Fprintf(&b, "DUE:%s\r\n", task.dueTime.UTC().Format(icsTimeFormat))
+		}
+		fmt.Fprintf(&b, "SUMMARY:%s\r\n", escapeICSText(task.name))
+		if task.done {
+			b.WriteString("STATUS:COMPLETED\r\n")
+		} else {
+			b.WriteString("STATUS:NEEDS-ACTION\r\n")
+		}
+		b.WriteString("END:VTODO\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	return b.String()
+}
+
+func escapeICSText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func (r *apiResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *apiResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *apiResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *apiResponse) send(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+	if _, err := w.Write(r.body.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, details string) {
+	writeAPIResponse(w, status, apiError{Error: details})
+}
+
+// Must run on the state goroutine
+func (a *app) resetFeedToken(u *user) (string, error) {
+	buf := make([]byte, feedTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	err := a.db.Exec("UPDATE users SET feed_token = ? WHERE id = ?;", token, u.uniqueID)
+	if err != nil {
+		return "", err
+	}
+	u.feedToken = token
+	return token, nil
+}
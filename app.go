@@ -3,15 +3,15 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
-	"remindMeBot/toml"
+	"net/http"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/genjidb/genji"
 	"github.com/genjidb/genji/document"
+	errs "github.com/genjidb/genji/errors"
 	"github.com/genjidb/genji/types"
 )
 
@@ -21,40 +21,92 @@ const (
 	timeFormat        = time.RFC822Z
 	initItemBufferCap = 20
 
-	reminderAlarm = "Reminder Notification"
-	taskAlarm     = "Task Notification"
+	reminderAlarm  = "Reminder Notification"
+	taskAlarm      = "Task Notification"
+	taskAssignment = "Task Assignment"
+
+	acceptEmoji  = "✅"
+	declineEmoji = "❌"
+
+	defaultLocale = "en-US"
 )
 
-var theApp *app
+// Tables holding rows of an item, keyed by item_id.
+// The notifications outlive the item, an undo brings it back
+// with the messages that act on it
+var itemTables = []string{
+	"item_recipients",
+	"task_assignments",
+	"item_tags",
+	"item_lists",
+	"item_nag_policies",
+}
 
 type (
 	app struct {
-		s               *discordgo.Session
+		s               messenger
 		remindChannelID string
 
 		db *genji.DB
 
 		users       map[string]*user
+		guilds      map[string]*guildSettings
 		shouldClose chan bool
-		mut         sync.Mutex
-		lastTime    time.Time
-		config      appConfig
+		clock       clock
+		api         *http.Server
+		// Once loaded, the users, the guilds and the config belong to
+		// the state goroutine, which runs the actions given to do. An
+		// action changes the state and writes it to the DB, it never
+		// waits on Discord or HTTP: messages are queued in the outbox
+		// and sent once the change is done
+		actions       chan func()
+		startState    sync.Once
+		lastTime      time.Time
+		outboxWake    chan bool
+		outboxMut     sync.Mutex
+		outboxLimiter channelLimiter
+		config        appConfig
+		configPath    string
+		configFlags   *configFlags
+		configModTime time.Time
 	}
 
 	appConfig struct {
-		ItemCounter       int32
 		ReminderFrequency int
+		Database          string
+		RemindChannel     string
 
 		AlarmTime struct {
 			First  int
 			Second int
 		}
+
+		API struct {
+			Enabled bool
+			Address string
+			// Sent by API clients as a bearer token, required when enabled
+			Token string
+		}
+
+		Webhooks map[string]webhookConfig
+
+		Outbox struct {
+			// Messages sent per channel every 5 seconds, 0 for no limit
+			ChannelRate int
+		}
 	}
 
 	user struct {
 		uniqueID  int
 		id        string
 		name      string
+		feedToken string
+		sortOrder sortOrder
+		digest    *digestSchedule
+		quiet     *quietHours
+		timeZone  *time.Location
+		dndUntil  time.Time
+		held      []heldAlarm
 		reminders []item
 		tasks     []item
 	}
@@ -68,6 +120,25 @@ type (
 		alarmCount     int
 		lastRemindTime time.Time
 		done           bool
+		recipients     []recipient
+		// Channel the item was created in, empty through the API
+		channelID string
+
+		nag      *nagPolicy
+		nagCount int
+		missed   bool
+
+		// Discord ID of the user who assigned the task,
+		// empty when the task belongs to its creator
+		assignedBy string
+		accepted   bool
+
+		list     string
+		tags     []string
+		priority priority
+
+		parentID int
+		subtasks []item
 	}
 
 	itemKind int
@@ -80,10 +151,66 @@ const (
 )
 
 func (a *app) init() {
-	configFile, _ := os.ReadFile("./data/config.toml")
-	toml.Deserialize(string(configFile), &a.config)
+	// Queued once the tables are no longer read
+	if a.load() {
+		a.renotify()
+	}
+}
 
-	userResults, err := a.db.Query("SELECT id, discord_id, name FROM users;")
+// Creates the tables and loads the users with their items.
+// Returns true when the items waiting for a reaction need
+// to be notified again
+func (a *app) load() (renotify bool) {
+	err := a.initSchema()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initWebhooks()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initTeam()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initLabels()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initDigests()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initNagPolicies()
+	if err != nil {
+		log.Panicln(err)
+	}
+	// Reactions only act on the messages recorded in notifications,
+	// the items of the messages sent before it existed are notified again
+	_, err = a.db.QueryDocument("SELECT COUNT(*) FROM notifications;")
+	renotify = errs.IsNotFoundError(err)
+	err = a.initOutbox()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initGuilds()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initHistory()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.initHeldAlarms()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.loadGuilds()
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	userResults, err := a.db.Query("SELECT id, discord_id, name, feed_token, sort_order, quiet_hours, time_zone, dnd_until FROM users;")
 	defer userResults.Close()
 	if err != nil {
 		log.Panicln(err)
@@ -92,15 +219,24 @@ func (a *app) init() {
 		var id int
 		var discordID string
 		var name string
-
-		err = document.Scan(d, &id, &discordID, &name)
-		a.users[discordID] = &user{
+		var feedToken string
+		var order string
+		var quiet string
+		var zone string
+		var dndUntil string
+
+		err = document.Scan(d, &id, &discordID, &name, &feedToken, &order, &quiet, &zone, &dndUntil)
+		u := &user{
 			uniqueID:  id,
 			id:        discordID,
 			name:      name,
+			feedToken: feedToken,
+			sortOrder: sortOrders[order],
 			reminders: make([]item, 0, initItemBufferCap),
 			tasks:     make([]item, 0, initItemBufferCap),
 		}
+		u.loadQuietSettings(quiet, zone, dndUntil)
+		a.users[discordID] = u
 		return err
 	})
 	if err != nil {
@@ -108,7 +244,8 @@ func (a *app) init() {
 	}
 
 	for _, u := range a.users {
-		itemResults, err := a.db.Query("SELECT id, name, kind, due_time, done FROM items WHERE user_id = ?;", u.uniqueID)
+		var subtasks []item
+		itemResults, err := a.db.Query("SELECT id, name, kind, due_time, done, priority, parent_id, nag_count, missed, channel_id FROM items WHERE user_id = ?;", u.uniqueID)
 		defer itemResults.Close()
 		if err != nil {
 			log.Panicln(err)
@@ -120,8 +257,13 @@ func (a *app) init() {
 			var kind int
 			var dueTimeStr string
 			var done int
+			var itemPriority int
+			var parentID int
+			var nagCount int
+			var missed int
+			var channelID string
 
-			err = document.Scan(d, &id, &name, &kind, &dueTimeStr, &done)
+			err = document.Scan(d, &id, &name, &kind, &dueTimeStr, &done, &itemPriority, &parentID, &nagCount, &missed, &channelID)
 			if err != nil {
 				return err
 			}
@@ -141,20 +283,22 @@ func (a *app) init() {
 				kind:       itemKind(kind),
 				hasDueDate: hasDueTime,
 				done:       done == 1,
+				priority:   priority(itemPriority),
+				parentID:   parentID,
+				nagCount:   nagCount,
+				missed:     missed == 1,
+				channelID:  channelID,
 			}
 			if hasDueTime {
 				newItem.dueTime = dueTime
-				remainingTime := int(dueTime.Sub(time.Now()).Minutes())
-				if remainingTime <= a.config.AlarmTime.First && remainingTime > a.config.AlarmTime.Second {
-					newItem.alarmCount = 1
-				} else if remainingTime <= a.config.AlarmTime.Second {
-					newItem.alarmCount = 2
-				}
+				newItem.alarmCount = a.sentAlarms(dueTime)
 			}
-			switch itemKind(kind) {
-			case itemReminder:
+			switch {
+			case newItem.parentID != 0:
+				subtasks = append(subtasks, newItem)
+			case newItem.kind == itemReminder:
 				u.reminders = append(u.reminders, newItem)
-			case itemTask:
+			case newItem.kind == itemTask:
 				u.tasks = append(u.tasks, newItem)
 			}
 			return err
@@ -162,24 +306,84 @@ func (a *app) init() {
 		if err != nil {
 			log.Panicln(err)
 		}
+		attachSubtasks(u, subtasks)
+	}
+
+	err = a.loadRecipients()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.loadAssignments()
+	if err != nil {
+		log.Panicln(err)
 	}
+	err = a.loadLabels()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.loadDigests()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.loadNagPolicies()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = a.loadHeldAlarms()
+	if err != nil {
+		log.Panicln(err)
+	}
+	return renotify
 }
 
-func (a *app) shutdown() {
-	configStr, err := toml.Serialize(&a.config)
-	fmt.Println(a.config)
-	fmt.Println(configStr)
+func (a *app) initSchema() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY,
+		discord_id TEXT NOT NULL,
+		name TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec(`CREATE TABLE IF NOT EXISTS items (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		kind INTEGER NOT NULL,
+		due_time TEXT NOT NULL,
+		done INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// Item IDs used to be counted in config.toml,
+	// the sequence picks up after the last stored item
+	d, err := a.db.QueryDocument("SELECT MAX(id) FROM items;")
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	err = os.WriteFile("./data/config.toml", []byte(configStr), 0644)
+	var lastID int
+	err = document.Scan(d, &lastID)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	return a.db.Exec(fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS item_seq START WITH %d;", lastID+1))
+}
+
+// Alarms an item due at that time has had by now, they are not sent again
+func (a *app) sentAlarms(dueTime time.Time) int {
+	remainingTime := int(dueTime.Sub(a.clock.now()).Minutes())
+	if remainingTime <= a.config.AlarmTime.First && remainingTime > a.config.AlarmTime.Second {
+		return 1
+	} else if remainingTime <= a.config.AlarmTime.Second {
+		return 2
 	}
+	return 0
 }
 
 func (a *app) run() {
-	a.lastTime = time.Now()
+	a.lastTime = a.clock.now()
 
 runLoop:
 	for {
@@ -189,21 +393,47 @@ runLoop:
 				break runLoop
 			}
 		default:
-			a.mut.Lock()
-
-			for _, user := range a.users {
-				a.updateUser(user)
-			}
-
-			a.lastTime = time.Now()
-			a.mut.Unlock()
+			a.tick()
 			time.Sleep(sleepTime)
 		}
 	}
 }
 
+// Runs the action on the state goroutine, started on first use,
+// and waits for it. Actions never call do themselves
+func (a *app) do(action func()) {
+	a.startState.Do(func() {
+		a.actions = make(chan func())
+		go a.runState()
+	})
+	done := make(chan bool)
+	a.actions <- func() {
+		defer close(done)
+		action()
+	}
+	<-done
+}
+
+func (a *app) runState() {
+	for action := range a.actions {
+		action()
+	}
+}
+
+func (a *app) tick() {
+	a.do(func() {
+		now := a.clock.now()
+		for _, user := range a.users {
+			a.updateUser(user)
+			a.releaseAlarms(user, now)
+			a.updateDigest(user, now.In(user.location()))
+		}
+		a.lastTime = now
+	})
+}
+
 func (a *app) updateUser(u *user) {
-	now := time.Now()
+	now := a.clock.now()
 	for i := range u.reminders {
 		reminder := &u.reminders[i]
 		timeRem := int(reminder.dueTime.Sub(now).Minutes())
@@ -211,227 +441,512 @@ func (a *app) updateUser(u *user) {
 			if !reminder.done {
 				reminder.lastRemindTime = now
 				reminder.done = true
-			} else {
-				remindRemaining := time.Since(reminder.lastRemindTime).Minutes()
-				if remindRemaining >= float64(a.config.ReminderFrequency) {
+			} else if !reminder.missed && now.Sub(reminder.lastRemindTime) >= a.nagInterval(reminder) {
+				if a.nagReminder(u, reminder) {
 					reminder.lastRemindTime = now
-					a.s.ChannelMessageSend(
-						a.remindChannelID,
-						fmt.Sprintf("<@%s>", u.id),
-					)
-					remindMsg, _ := a.s.ChannelMessageSendEmbed(
-						a.remindChannelID,
-						&discordgo.MessageEmbed{
-							Type:        discordgo.EmbedTypeRich,
-							Title:       reminderAlarm,
-							Description: fmt.Sprintf("Have you done **%s**?", reminder.name),
-						},
-					)
-					a.s.MessageReactionAdd(
-						a.remindChannelID,
-						remindMsg.ID,
-						"☑",
-					)
 				}
 			}
 		} else {
 			if timeRem <= a.config.AlarmTime.First && timeRem > a.config.AlarmTime.Second {
-				if reminder.alarmCount == 0 {
+				if reminder.alarmCount == 0 && a.sendAlarm(u, reminder, fmt.Sprintf("**%s** is in less than 120 minutes (~%d)", reminder.name, timeRem), false) {
 					reminder.alarmCount = 1
-
-					a.s.ChannelMessageSend(
-						a.remindChannelID,
-						fmt.Sprintf("<@%s>", u.id),
-					)
-					a.s.ChannelMessageSendEmbed(
-						a.remindChannelID,
-						&discordgo.MessageEmbed{
-							Type:        discordgo.EmbedTypeRich,
-							Title:       reminderAlarm,
-							Description: fmt.Sprintf("**%s** is in less than 120 minutes (~%d)", reminder.name, timeRem),
-						},
-					)
 				}
 			} else if timeRem <= a.config.AlarmTime.Second {
-				if reminder.alarmCount < 2 {
+				if reminder.alarmCount < 2 && a.sendAlarm(u, reminder, fmt.Sprintf("**%s** is in less than 30 minutes (~%d)", reminder.name, timeRem), false) {
 					reminder.alarmCount = 2
-
-					a.s.ChannelMessageSend(
-						a.remindChannelID,
-						fmt.Sprintf("<@%s>", u.id),
-					)
-					a.s.ChannelMessageSendEmbed(
-						a.remindChannelID,
-						&discordgo.MessageEmbed{
-							Type:        discordgo.EmbedTypeRich,
-							Title:       reminderAlarm,
-							Description: fmt.Sprintf("**%s** is in less than 30 minutes (~%d)", reminder.name, timeRem),
-						},
-					)
 				}
 			}
 		}
 	}
 }
 
+// Pings the owner of the reminder, or its pending recipients
+// when it is shared. Nags can be acknowledged with ☑.
+// Alarms wait for the end of the quiet hours of the owner.
+// Returns false when the alarm could not be queued,
+// the scheduler tries again on its next tick.
+// Must run on the state goroutine
+func (a *app) sendAlarm(u *user, reminder *item, description string, nag bool) (handled bool) {
+	if a.holdAlarm(u, reminder, description, a.clock.now()) {
+		return true
+	}
+	mentions := []string{fmt.Sprintf("<@%s>", u.id)}
+	var channels []string
+	if len(reminder.recipients) > 0 {
+		mentions, channels = pendingRecipients(reminder.recipients)
+	}
+	var messages []*outboxMessage
+	if len(mentions) > 0 {
+		messages = append(messages, textMessage(a.remindChannelID, strings.Join(mentions, " ")))
+		channels = append([]string{a.remindChannelID}, channels...)
+	}
+
+	result := &commandResult{
+		title:       reminderAlarm,
+		description: description,
+	}
+	var reactions []string
+	if nag {
+		reactions = append(reactions, "☑")
+	}
+	for _, channelID := range channels {
+		messages = append(messages, resultMessage(channelID, result, reactions...).about(reminder.id))
+	}
+	if err := a.enqueue(messages...); err != nil {
+		return false
+	}
+	a.emitEvent(eventAlarmFired, u, reminder)
+	a.recordChange("", changeAlarmed, nil, snapshotItem(u, reminder))
+	return true
+}
+
+func (a *app) handleMessage(m *discordgo.MessageCreate) {
+	if m.Author.ID == a.s.botUserID() {
+		return
+	}
+	var content string
+	var ok bool
+	a.do(func() {
+		content, ok = a.guild(m.GuildID).commandText(m.Content, a.s.botUserID())
+	})
+	if !ok {
+		return
+	}
+	cmd, err := parseCommand(content)
+	if !err.isOK() {
+		a.handleError(m.ChannelID, err)
+		return
+	}
+	if cmd == nil {
+		return
+	}
+	a.handleCommand(m.Message, cmd)
+}
+
+func (a *app) handleReaction(m *discordgo.MessageReactionAdd) {
+	if m.UserID == a.s.botUserID() {
+		return
+	}
+	if m.Emoji.Name == prevPageEmoji || m.Emoji.Name == nextPageEmoji {
+		err := a.s.turnPage(m.ChannelID, m.MessageID, m.UserID, m.Emoji.Name == nextPageEmoji)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if m.Emoji.Name != "☑" && m.Emoji.Name != acceptEmoji && m.Emoji.Name != declineEmoji {
+		return
+	}
+	var roles []string
+	if m.Member != nil {
+		roles = m.Member.Roles
+	}
+
+	// The items come from the record of the notification, not its text,
+	// a reaction never acts on another item with the same name
+	itemIDs := a.notifiedItems(m.MessageID)
+	if len(itemIDs) == 0 {
+		return
+	}
+
+	a.do(func() {
+		forOthers := a.guild(m.GuildID).allows(roles, capabilityAcknowledge)
+		for _, itemID := range itemIDs {
+			owner, it := a.itemOwner(itemID)
+			if it == nil {
+				continue
+			}
+			switch {
+			case it.kind == itemReminder && m.Emoji.Name == "☑":
+				a.acknowledgeReminder(owner, it.id, m.UserID, m.ChannelID, roles, forOthers)
+			case it.kind == itemTask && m.Emoji.Name != "☑":
+				// Only the assignee answers an assignment
+				if m.UserID == owner.id {
+					a.answerAssignment(owner, it.id, m.Emoji.Name == acceptEmoji)
+				}
+			case it.kind == itemTask && (m.UserID == owner.id || forOthers):
+				completed := *it
+				a.deleteItem(owner, completed.id)
+				a.emitCompletion(owner, &completed)
+				a.recordChange(m.UserID, changeCompleted, snapshotItem(owner, &completed), nil)
+			}
+		}
+	})
+}
+
 func (a *app) handleError(channelID string, err parserError) {
-	a.mut.Lock()
-	defer a.mut.Unlock()
+	a.enqueue(resultMessage(channelID, makeErrorResult(err)))
+}
 
-	var errString string
+func makeErrorResult(err parserError) *commandResult {
+	result := &commandResult{
+		status:      statusError,
+		description: err.details,
+	}
 
 	switch err.kind {
 	case errorInvalidToken:
-		errString = fmt.Sprintf("Invalid token: %s", err.details)
+		result.title = "Invalid token"
 
 	case errorInvalidSyntax:
-		errString = fmt.Sprintf("Invalid syntax: %s", err.details)
+		result.title = "Invalid syntax"
 
 	case errorInvalidDate:
-		errString = fmt.Sprintf("Invalid date: %s", err.details)
+		result.title = "Invalid date"
 
 	case errorUnknownCommand:
-		errString = fmt.Sprintf("Unknown command: %s", err.details)
+		result.title = "Unknown command"
 
 	}
-
-	_, msgerr := a.s.ChannelMessageSend(channelID, errString)
-	if msgerr != nil {
-		log.Println(msgerr)
-	}
+	return result
 }
 
 func (a *app) handleCommand(m *discordgo.Message, cmd command) {
-	a.mut.Lock()
-	defer a.mut.Unlock()
+	// Downloaded beforehand, a slow server would stall every user
+	if importCmd, ok := cmd.(*importMeCommand); ok {
+		importCmd.files = fetchAttachments(m.Attachments)
+	}
 
-	if _, exist := a.users[m.Author.ID]; !exist {
-		// Not in memory, checking DB
-		result, err := a.db.Query("SELECT id, discord_id, name FROM users WHERE discord_id = ?;", m.Author.ID)
+	a.do(func() {
+		user, err := a.resolveUser(m.Author)
 		if err != nil {
 			log.Println(err)
 			return
 		}
+		ctx := a.newContext(m)
+		if required := a.missingCapability(ctx, cmd, m.Author.ID); required != "" {
+			a.enqueue(resultMessage(m.ChannelID, permissionDenied(cmd, required)))
+			return
+		}
+		result, _ := a.executeCommand(ctx, user, cmd)
+		a.enqueue(resultMessage(m.ChannelID, result))
+	})
+}
 
-		var count int
-		var u *user
-		err = result.Iterate(func(d types.Document) error {
-			u = &user{}
+// Context of a command sent in the message,
+// or of a command from the API when the message is nil
+func (a *app) newContext(m *discordgo.Message) *commandContext {
+	ctx := &commandContext{app: a, clock: a.clock, locale: defaultLocale}
+	if m == nil {
+		return ctx
+	}
+	ctx.guildID, ctx.channelID, ctx.message = m.GuildID, m.ChannelID, m
+	if m.Member != nil {
+		ctx.roles = m.Member.Roles
+	}
+	if m.Author != nil && m.Author.Locale != "" {
+		ctx.locale = m.Author.Locale
+	}
+	return ctx
+}
 
-			err = document.Scan(d, &u.uniqueID, &u.id, &u.name)
-			count += 1
-			return err
-		})
-		result.Close()
-		if count > 1 {
-			log.Printf(
-				"Duplicate User in database: id: %d, discordID: %s, name: %s",
-				u.uniqueID,
-				u.id,
-				u.name,
-			)
-			return
-		} else if count == 0 {
-			err = a.registerUser(m.Author)
-			if err != nil {
-				log.Println("DB access failure: ", err)
-				return
-			}
+// Executes the command for the user and persists its result.
+// Must run on the state goroutine
+func (a *app) executeCommand(ctx *commandContext, user *user, cmd command) (result *commandResult, it *item) {
+	result, it = cmd.execute(ctx, user)
+	if c, ok := cmd.(committer); ok {
+		if failure := c.commit(ctx, user, it); failure != nil {
+			result = failure
+		}
+	}
+	return
+}
+
+// Stores the item created by the author for its owner.
+// An item that cannot be stored is dropped from the lists of the owner.
+// Must run on the state goroutine
+func (a *app) createItem(author, owner *user, it *item) bool {
+	if err := a.insertItems(owner, []item{*it}); err != nil {
+		log.Println("DB access failure: ", err)
+		owner.dropItem(it.id)
+		return false
+	}
+	a.emitEvent(eventItemCreated, owner, it)
+	a.recordChange(author.id, changeCreated, nil, snapshotItem(owner, it))
+	return true
+}
+
+// Returns the user, registering them on their first use
+// and refreshing their name when it changed.
+// Must run on the state goroutine
+func (a *app) resolveUser(du *discordgo.User) (*user, error) {
+	u, registered, err := a.findUser(du)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		if err = a.registerUser(u); err != nil {
+			return nil, fmt.Errorf("DB access failure: %v", err)
 		}
+		return u, nil
 	}
-	user := a.users[m.Author.ID]
-	confirmationMsg, it := cmd.execute(user)
-	if it != nil {
-		switch cmd.(type) {
-		case *remindMeCommand, *staffMeCommand:
+	a.refreshName(u, du)
+	return u, nil
+}
+
+// Returns the user, or a new one to register when they are not
+// in the database yet.
+// Must run on the state goroutine
+func (a *app) findUser(du *discordgo.User) (u *user, registered bool, err error) {
+	if u, exist := a.users[du.ID]; exist {
+		return u, true, nil
+	}
+
+	// Not in memory, checking DB
+	result, err := a.db.Query("SELECT id, discord_id, name FROM users WHERE discord_id = ?;", du.ID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var count int
+	err = result.Iterate(func(d types.Document) error {
+		u = &user{
+			reminders: make([]item, 0, initItemBufferCap),
+			tasks:     make([]item, 0, initItemBufferCap),
+		}
+
+		err = document.Scan(d, &u.uniqueID, &u.id, &u.name)
+		count += 1
+		return err
+	})
+	result.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	switch count {
+	case 0:
+		return &user{
+			id:        du.ID,
+			name:      du.Username,
+			reminders: make([]item, 0, initItemBufferCap),
+			tasks:     make([]item, 0, initItemBufferCap),
+		}, false, nil
+	case 1:
+		a.users[du.ID] = u
+		return u, true, nil
+	default:
+		return nil, false, fmt.Errorf(
+			"Duplicate User in database: id: %d, discordID: %s, name: %s",
+			u.uniqueID,
+			u.id,
+			u.name,
+		)
+	}
+}
+
+// Users mentioned before they spoke are registered under their ID
+// as a placeholder, their name is known once they write to the bot.
+// Must run on the state goroutine
+func (a *app) refreshName(u *user, du *discordgo.User) {
+	if du.Username == "" || du.Username == du.ID || du.Username == u.name {
+		return
+	}
+	u.name = du.Username
+	err := a.db.Exec("UPDATE users SET name = ? WHERE id = ?;", u.name, u.uniqueID)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Must run on the state goroutine
+func (a *app) registerUser(u *user) error {
+	u.uniqueID = len(a.users)
+	err := a.db.Exec("INSERT INTO users (id, discord_id, name) VALUES (?, ?, ?);", u.uniqueID, u.id, u.name)
+	if err != nil {
+		return err
+	}
+	a.users[u.id] = u
+	return nil
+}
+
+func (a *app) insertItems(u *user, items []item) error {
+	return a.db.Update(func(tx *genji.Tx) error {
+		for _, it := range items {
 			dueTimeStr := ""
 			if it.hasDueDate {
 				dueTimeStr = it.dueTime.Format(timeFormat)
 			}
-			err := a.db.Exec(
-				"INSERT INTO items (id, name, user_id, kind, due_time, done) VALUES (?, ?, ?, ?, ?, ?);",
+			err := tx.Exec(
+				"INSERT INTO items (id, name, user_id, kind, due_time, done, priority, parent_id, channel_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
 				it.id,
 				it.name,
-				user.uniqueID,
+				u.uniqueID,
 				it.kind,
 				dueTimeStr,
 				it.done,
+				it.priority,
+				it.parentID,
+				it.channelID,
 			)
 			if err != nil {
-				log.Println("DB access failure: ", err)
+				return err
 			}
-
-		case *removeMeCommand:
-			err := a.db.Exec("DELETE FROM items WHERE id = ?;", it.id)
+			err = insertRecipients(tx, &it)
 			if err != nil {
-				log.Println("DB access failure: ", err)
+				return err
+			}
+			err = insertAssignment(tx, &it)
+			if err != nil {
+				return err
+			}
+			err = insertLabels(tx, &it)
+			if err != nil {
+				return err
+			}
+			err = insertNagPolicy(tx, &it)
+			if err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+}
 
-	}
+// Deletes the item and its subtasks along with the rows referencing them
+func (a *app) dbDeleteItem(it *item) error {
+	return a.db.Update(func(tx *genji.Tx) error {
+		return deleteItemRows(tx, it)
+	})
+}
 
-	_, err := a.s.ChannelMessageSendEmbed(m.ChannelID, confirmationMsg)
+func deleteItemRows(tx *genji.Tx, it *item) error {
+	err := tx.Exec("DELETE FROM items WHERE id = ?;", it.id)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	for _, table := range itemTables {
+		err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE item_id = ?;", table), it.id)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range it.subtasks {
+		err = deleteItemRows(tx, &it.subtasks[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finds the item, a subtask included, and the user it belongs to.
+// Must run on the state goroutine
+func (a *app) itemOwner(id int) (*user, *item) {
+	if id == 0 {
+		return nil, nil
+	}
+	for _, u := range a.users {
+		if index := findItemByID(u.reminders, id); index != -1 {
+			return u, &u.reminders[index]
+		}
+		if task, _ := findTask(u.tasks, id); task != nil {
+			return u, task
+		}
 	}
+	return nil, nil
 }
 
-func (a *app) registerUser(u *discordgo.User) error {
-	newUser := &user{
-		uniqueID:  len(a.users),
-		id:        u.ID,
-		name:      u.Username,
-		reminders: make([]item, 0, initItemBufferCap),
-		tasks:     make([]item, 0, initItemBufferCap),
+// Must run on the state goroutine
+func (a *app) emitCompletion(u *user, it *item) {
+	switch it.kind {
+	case itemReminder:
+		a.emitEvent(eventReminderAcknowledged, u, it)
+	case itemTask:
+		a.emitEvent(eventTaskDone, u, it)
 	}
-	err := a.db.Exec("INSERT INTO users (id, discord_id, name) VALUES (?, ?, ?);", newUser.uniqueID, newUser.id, newUser.name)
+}
+
+// Must run on the state goroutine
+func (a *app) genItemID() int {
+	d, err := a.db.QueryDocument("SELECT NEXT VALUE FOR item_seq;")
 	if err != nil {
-		return err
+		log.Panicln(err)
 	}
-	a.users[u.ID] = newUser
-	return nil
+	var itemID int
+	err = document.Scan(d, &itemID)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return itemID
 }
 
-func (a *app) removeItem(u *discordgo.User, itemName string, kind itemKind) {
-	if user, exist := a.users[u.ID]; exist {
-		var removed item
-		switch kind {
-		case itemReminder:
-			index := findItemByName(user.reminders, itemName)
-			if index == -1 {
-				log.Panicf("No item with name %s", itemName)
-				return
-			}
-			removed = user.reminders[index]
-			if len(user.reminders) > 1 {
-				copy(user.reminders[index-1:], user.reminders[index:])
-				user.reminders = user.reminders[:len(user.reminders)-1]
-			} else {
-				user.reminders = user.reminders[:0]
-			}
+// Tasks are marked as done, reminders are removed
+// the same way the ☑ reaction does.
+// Must run on the state goroutine
+func (a *app) completeItem(u *user, id int) (completed item, found bool) {
+	if index := findItemByID(u.reminders, id); index != -1 {
+		completed = u.reminders[index]
+		before := snapshotItem(u, &completed)
+		completed.done = true
+		a.deleteItem(u, id)
+		a.emitCompletion(u, &completed)
+		a.recordChange(u.id, changeAcknowledged, before, nil)
+		return completed, true
+	}
 
-		case itemTask:
-			index := findItemByName(user.tasks, itemName)
-			if index == -1 {
-				log.Panicf("No item with name %s", itemName)
-				return
-			}
-			removed = user.tasks[index]
-			if len(user.tasks) > 1 {
-				copy(user.tasks[index-1:], user.tasks[index:])
-				user.tasks = user.tasks[:len(user.reminders)-1]
-			} else {
-				user.tasks = user.tasks[:0]
-			}
-		}
-		err := a.db.Exec("DELETE FROM items WHERE id = ?;", removed.id)
-		if err != nil {
-			log.Println("DB access failure: ", err)
+	task, _ := findTask(u.tasks, id)
+	if task == nil {
+		return
+	}
+	before := snapshotItem(u, task)
+	task.done = true
+	a.saveTaskDone(u, task)
+	a.recordChange(u.id, changeCompleted, before, snapshotItem(u, task))
+	return *task, true
+}
+
+// Removes the item from the lists of the user, leaving the DB as is.
+// The parent ID is the one of the task of a subtask
+func (u *user) dropItem(id int) (removed item, parentID int, found bool) {
+	if index := findItemByID(u.reminders, id); index != -1 {
+		removed = u.reminders[index]
+		u.reminders = removeItemByID(u.reminders, id)
+		return removed, 0, true
+	}
+	task, parent := findTask(u.tasks, id)
+	if task == nil {
+		return
+	}
+	removed = *task
+	if parent != nil {
+		parentID = parent.id
+		parent.subtasks = removeItemByID(parent.subtasks, id)
+	} else {
+		u.tasks = removeItemByID(u.tasks, id)
+	}
+	return removed, parentID, true
+}
+
+// Adds the item to the lists of the user, leaving the DB as is.
+// A subtask whose task is gone comes back as a task
+func (u *user) putItem(it item) {
+	if it.parentID != 0 {
+		if parent := findItemByID(u.tasks, it.parentID); parent != -1 {
+			u.tasks[parent].subtasks = append(u.tasks[parent].subtasks, it)
+			return
 		}
+		it.parentID = 0
+	}
+	if it.kind == itemReminder {
+		u.reminders = append(u.reminders, it)
+	} else {
+		u.tasks = append(u.tasks, it)
 	}
 }
 
-func (a *app) genItemID() int {
-	itemID := atomic.SwapInt32(&a.config.ItemCounter, a.config.ItemCounter+1)
-	return int(itemID)
+// Must run on the state goroutine
+func (a *app) deleteItem(u *user, id int) bool {
+	removed, parentID, found := u.dropItem(id)
+	if !found {
+		return false
+	}
+
+	err := a.dbDeleteItem(&removed)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	if parentID != 0 {
+		a.syncParent(u, parentID)
+	}
+	return true
 }
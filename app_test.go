@@ -0,0 +1,1545 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/genjidb/genji/document"
+)
+
+func countItems(t *testing.T, a *app) int {
+	d, err := a.db.QueryDocument("SELECT COUNT(*) FROM items;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = document.Scan(d, &count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestReminderFlow(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "alice", "!remindme pick up the milk, 10-01-30 13:00")
+	if msg := discord.last(); msg.result == nil || msg.result.title != "Remind me!" {
+		t.Fatalf("invalid confirmation, got %#v", msg)
+	}
+	if count := countItems(t, a); count != 1 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 1, count)
+	}
+
+	sentBefore := len(discord.sent())
+	discord.tick()
+	if sent := len(discord.sent()); sent != sentBefore {
+		t.Errorf("unexpected alarm, expected %d messages got %d", sentBefore, sent)
+	}
+
+	alarms := []struct {
+		advance time.Duration
+		expect  string
+	}{
+		{61 * time.Minute, "**pick up the milk** is in less than 120 minutes (~119)"},
+		{90 * time.Minute, "**pick up the milk** is in less than 30 minutes (~29)"},
+	}
+	for _, alarm := range alarms {
+		clock.advance(alarm.advance)
+		discord.tick()
+
+		sent := discord.sent()
+		ping, embed := sent[len(sent)-2], sent[len(sent)-1]
+		if ping.channelID != fakeRemindChannel || ping.content != "<@alice>" {
+			t.Errorf("invalid ping, got %#v", ping)
+		}
+		if embed.result == nil || embed.result.description != alarm.expect {
+			t.Errorf("invalid alarm, expected %s got %#v", alarm.expect, embed.result)
+		}
+	}
+
+	// The first tick past the due time is silent
+	clock.advance(30 * time.Minute)
+	sentBefore = len(discord.sent())
+	discord.tick()
+	if sent := len(discord.sent()); sent != sentBefore {
+		t.Errorf("unexpected alarm, expected %d messages got %d", sentBefore, sent)
+	}
+
+	clock.advance(time.Duration(a.config.ReminderFrequency) * time.Minute)
+	discord.tick()
+	nag := discord.last()
+	expect := "Have you done **pick up the milk**?"
+	if nag.result == nil || nag.result.description != expect {
+		t.Fatalf("invalid reminder, expected %s got %#v", expect, nag.result)
+	}
+	if len(nag.reactions) != 1 || nag.reactions[0] != "☑" {
+		t.Errorf("invalid reactions, expected [☑] got %v", nag.reactions)
+	}
+
+	discord.injectReaction(nag.channelID, nag.id, "alice", "☑")
+	if len(a.users["alice"].reminders) != 0 {
+		t.Errorf("reminder was not acknowledged, got %v", a.users["alice"].reminders)
+	}
+	if count := countItems(t, a); count != 0 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 0, count)
+	}
+}
+
+func TestTaskCommands(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	inputs := []string{
+		"!staffme write tests",
+		"!staffme review the parser, 12-01-30",
+		"!briefme",
+		"!removeme task, write tests",
+		"!removeme task, write tests",
+		"!bogus",
+	}
+	expects := []struct {
+		title  string
+		status resultStatus
+	}{
+		{"Staff me!", statusOK},
+		{"Staff me!", statusOK},
+		{"Brief me!", statusOK},
+		{"Remove me!", statusOK},
+		{"Remove me!", statusError},
+		{"Unknown command", statusError},
+	}
+
+	for i, input := range inputs {
+		t.Logf("input %d", i)
+		discord.injectMessage(fakeCommandChannel, "bob", input)
+
+		msg := discord.last()
+		if msg.channelID != fakeCommandChannel || msg.result == nil {
+			t.Fatalf("invalid response, got %#v", msg)
+		}
+		if msg.result.title != expects[i].title || msg.result.status != expects[i].status {
+			t.Errorf(
+				"invalid result, expected %s (%d) got %s (%d)",
+				expects[i].title,
+				expects[i].status,
+				msg.result.title,
+				msg.result.status,
+			)
+		}
+	}
+
+	tasks := a.users["bob"].tasks
+	if len(tasks) != 1 || tasks[0].name != "review the parser" {
+		t.Errorf("invalid tasks, got %v", tasks)
+	}
+	if count := countItems(t, a); count != 1 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 1, count)
+	}
+
+	brief := discord.sent()[2].result
+	result := renderText(brief)
+	expect := "== Brief me! ==\nReminders:\n  No active reminders\nTasks:\n  [ ] review the parser\n  [ ] write tests\n"
+	if result != expect {
+		t.Errorf("invalid brief, expected %q got %q", expect, result)
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "bob", "!remindme call alice, 10-01-30 11:00")
+	discord.injectMessage(fakeCommandChannel, "bob", "!staffme release")
+	discord.injectMessage(fakeCommandChannel, "bob", "!staffme release > publish")
+	if err := a.db.Exec("DROP TABLE items;"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Items that cannot be stored are not kept in memory either,
+	// and those that cannot be removed stay
+	inputs := []struct {
+		input string
+		title string
+	}{
+		{"!remindme standup, 10-01-30 11:30", "Remind me!"},
+		{"!remindus <@100>, standup, 10-01-30 11:30", "Remind us!"},
+		{"!staffme write tests", "Staff me!"},
+		{"!staffme release > notes", "Staff me!"},
+		{"!removeme reminder, call alice", "Remove me!"},
+		{"!removeme task, release > publish", "Remove me!"},
+	}
+	for i, input := range inputs {
+		t.Logf("input %d", i)
+		discord.injectMessage(fakeCommandChannel, "bob", input.input)
+		result := discord.last().result
+		if result == nil || result.title != input.title || result.status != statusError {
+			t.Errorf("expected a %s error, got %#v", input.title, result)
+		}
+	}
+	u := a.users["bob"]
+	if len(u.reminders) != 1 || len(u.tasks) != 1 || len(u.tasks[0].subtasks) != 1 {
+		t.Errorf("invalid items, got %v and %v", u.reminders, u.tasks)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte("ReminderFrequency = 0\nDatabase = \"file.db\"\n[AlarmTime]\nFirst = 30\nSecond = 30\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envDatabase, "env.db")
+	t.Setenv(envToken, "")
+	t.Setenv(envTokenFile, "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := newConfigFlags(fs)
+	fs.Parse([]string{"-config", path, "-channel", "1234"})
+	flags.collect(fs)
+
+	config, err := loadConfig(flags, true)
+	errs, ok := err.(configErrors)
+	if !ok {
+		t.Fatalf("invalid error, expected configErrors got %#v", err)
+	}
+	if len(errs) != 3 {
+		t.Errorf("invalid number of errors, expected %d got %d: %v", 3, len(errs), errs)
+	}
+	if config.app.Database != "env.db" {
+		t.Errorf("invalid database, expected %s got %s", "env.db", config.app.Database)
+	}
+	if config.app.RemindChannel != "1234" {
+		t.Errorf("invalid channel, expected %s got %s", "1234", config.app.RemindChannel)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+	discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+	config := defaultAppConfig()
+	config.API.Enabled = true
+	if errs := config.validate(); len(errs) != 1 {
+		t.Errorf("expected the API to be refused without a token, got %v", errs)
+	}
+	a.config.API.Token = "secret"
+
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(path, feedPrefix) {
+			a.serveFeed(w, r)
+		} else {
+			a.serveAPI(w, r)
+		}
+		return w
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if w := serve(http.MethodDelete, "/api/users/100/items/1", token, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("invalid status with token %q, expected %d got %d", token, http.StatusUnauthorized, w.Code)
+		}
+	}
+	w := serve(http.MethodPost, "/api/users/100/items", "secret", `{"kind": "task", "name": "milk, eggs"}`)
+	if w.Code != http.StatusCreated || a.users["100"].tasks[0].name != "milk, eggs" {
+		t.Errorf("invalid item creation, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(http.MethodPost, "/api/users/100/feed", "secret", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid feed creation, got %d %s", w.Code, w.Body.String())
+	}
+	feed := feedPrefix + a.users["100"].feedToken + feedExt
+	w = serve(http.MethodGet, feed, "", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid feed status, expected %d got %d", http.StatusOK, w.Code)
+	}
+	if stamp := "DTSTAMP:" + start.UTC().Format(icsTimeFormat); !strings.Contains(w.Body.String(), stamp) {
+		t.Errorf("invalid feed, expected %s got %q", stamp, w.Body.String())
+	}
+	if w := serve(http.MethodGet, feedPrefix+"wrong"+feedExt, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("invalid feed status, expected %d got %d", http.StatusNotFound, w.Code)
+	}
+
+	if err := a.db.Exec("DROP TABLE items;"); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(http.MethodPost, "/api/users/100/items", "secret", `{"kind": "task", "name": "lost"}`)
+	if w.Code != http.StatusInternalServerError || len(a.users["100"].tasks) != 1 {
+		t.Errorf("invalid failed item creation, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, _, _ := newTestApp(t, start)
+	t.Setenv(envDatabase, "")
+	t.Setenv(envChannel, "")
+	os.Unsetenv(envDatabase)
+	os.Unsetenv(envChannel)
+
+	a.configPath = filepath.Join(t.TempDir(), "config.toml")
+	a.config.Database = "./data/remindme"
+	a.config.RemindChannel = "649758541376127015"
+	a.config.API.Address = "127.0.0.1:8080"
+	a.config.Outbox.ChannelRate = defaultChannelRate
+
+	write := func(content string) {
+		if err := os.WriteFile(a.configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ReminderFrequency = 15\nDatabase = \"other.db\"\n[AlarmTime]\nFirst = 60\nSecond = 30\n")
+	old := a.config
+	a.reloadConfig()
+	if a.config.ReminderFrequency != 15 || a.config.AlarmTime.First != 60 {
+		t.Errorf("config was not reloaded, got %+v", a.config)
+	}
+	if a.config.Database != old.Database {
+		t.Errorf("invalid database, expected %s got %s", old.Database, a.config.Database)
+	}
+
+	changes := diffConfig(old, a.config)
+	expects := []string{"ReminderFrequency: 30 -> 15", "AlarmTime.First: 120 -> 60"}
+	if len(changes) != len(expects) {
+		t.Fatalf("invalid diff, expected %v got %v", expects, changes)
+	}
+	for i := range expects {
+		if changes[i] != expects[i] {
+			t.Errorf("invalid change, expected %s got %s", expects[i], changes[i])
+		}
+	}
+
+	write("ReminderFrequency = \"often\"\n")
+	current := a.config
+	a.reloadConfig()
+	if a.config.ReminderFrequency != current.ReminderFrequency {
+		t.Errorf("invalid config kept, expected %d got %d", current.ReminderFrequency, a.config.ReminderFrequency)
+	}
+}
+
+func TestSharedReminder(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "100", "!remindus <@200> <@&42> <#99>, standup, 10-01-30 11:00")
+	if msg := discord.last(); msg.result == nil || msg.result.status != statusOK {
+		t.Fatalf("invalid confirmation, got %#v", msg.result)
+	}
+
+	nag := func() (ping, alarm *fakeMessage) {
+		clock.advance(time.Duration(a.config.ReminderFrequency) * time.Minute)
+		discord.tick()
+		for _, msg := range discord.sent() {
+			if msg.channelID == fakeRemindChannel && msg.result == nil {
+				ping = msg
+			}
+			if msg.channelID == fakeRemindChannel && msg.result != nil {
+				alarm = msg
+			}
+		}
+		return
+	}
+
+	// Past the due time, the first tick is silent
+	clock.advance(61 * time.Minute)
+	discord.tick()
+	ping, alarm := nag()
+	if ping.content != "<@100> <@200> <@&42>" {
+		t.Errorf("invalid ping, expected %s got %s", "<@100> <@200> <@&42>", ping.content)
+	}
+	if channel := discord.last(); channel.channelID != "99" || len(channel.reactions) != 1 {
+		t.Errorf("invalid channel alarm, got %#v", channel)
+	}
+
+	discord.injectReaction(alarm.channelID, alarm.id, "200", "☑")
+	discord.injectReaction(alarm.channelID, alarm.id, "300", "☑", "42")
+	ping, alarm = nag()
+	if ping.content != "<@100>" {
+		t.Errorf("invalid ping, expected %s got %s", "<@100>", ping.content)
+	}
+	if count := countItems(t, a); count != 1 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 1, count)
+	}
+
+	discord.injectReaction(alarm.channelID, alarm.id, "100", "☑")
+	if len(a.users["100"].reminders) != 1 {
+		t.Errorf("reminder acknowledged before the channel, got %v", a.users["100"].reminders)
+	}
+	channel := discord.last()
+	discord.injectReaction(channel.channelID, channel.id, "400", "☑")
+	if len(a.users["100"].reminders) != 0 {
+		t.Errorf("reminder was not acknowledged, got %v", a.users["100"].reminders)
+	}
+	if count := countItems(t, a); count != 0 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 0, count)
+	}
+}
+
+func TestTaskAssignment(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "100", "!staffme <@200>, review PR, 20-06-30")
+	if msg := discord.last(); msg.result == nil || msg.result.status != statusOK {
+		t.Fatalf("invalid confirmation, got %#v", msg.result)
+	}
+	sent := discord.sent()
+	ping, assignment := sent[0], sent[1]
+	if ping.content != "<@200>" || assignment.result.title != taskAssignment {
+		t.Fatalf("invalid notification, got %#v %#v", ping, assignment.result)
+	}
+	tasks := a.users["200"].tasks
+	if len(tasks) != 1 || tasks[0].assignedBy != "100" || tasks[0].accepted {
+		t.Fatalf("invalid assigned tasks, got %v", tasks)
+	}
+
+	discord.injectReaction(assignment.channelID, assignment.id, "200", declineEmoji)
+	if len(a.users["200"].tasks) != 0 || len(a.users["100"].tasks) != 1 {
+		t.Fatalf("task was not declined, got %v and %v", a.users["200"].tasks, a.users["100"].tasks)
+	}
+
+	discord.injectMessage(fakeCommandChannel, "100", "!reassignme <@300>, review PR")
+	assignment = discord.sent()[len(discord.sent())-2]
+	discord.injectReaction(assignment.channelID, assignment.id, "300", acceptEmoji)
+	tasks = a.users["300"].tasks
+	if len(tasks) != 1 || tasks[0].assignedBy != "100" || !tasks[0].accepted {
+		t.Fatalf("task was not accepted, got %v", tasks)
+	}
+
+	inputs := []string{"!briefme", "!briefteam"}
+	expects := []string{
+		"== Brief me! ==\nReminders:\n  No active reminders\nTasks:\n  No active tasks\nAssigned to others:\n  [ ] review PR (to <@300>)\n",
+		"== Brief team! ==\n300:\n  [ ] review PR (from <@100>)\n",
+	}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if result := renderText(discord.last().result); result != expects[i] {
+			t.Errorf("invalid brief, expected %q got %q", expects[i], result)
+		}
+	}
+
+	// The assignment survives a restart
+	a.users = make(map[string]*user)
+	a.init()
+	tasks = a.users["300"].tasks
+	if len(tasks) != 1 || tasks[0].assignedBy != "100" || !tasks[0].accepted {
+		t.Errorf("invalid loaded tasks, got %v", tasks)
+	}
+
+	// The placeholder name goes once the assignee writes to the bot
+	a.handleMessage(&discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			GuildID:   fakeGuild,
+			ChannelID: fakeCommandChannel,
+			Content:   "!briefteam",
+			Author:    &discordgo.User{ID: "300", Username: "carol"},
+		},
+	})
+	a.deliverOutbox()
+	if result := renderText(discord.last().result); !strings.Contains(result, "\ncarol:\n") {
+		t.Errorf("invalid team brief after the assignee spoke, got %q", result)
+	}
+
+	// Pending assignments are notified again when upgrading
+	// from a version without notification records
+	discord.injectMessage(fakeCommandChannel, "100", "!staffme <@200>, merge PR")
+	if err := a.db.Exec("DROP TABLE notifications;"); err != nil {
+		t.Fatal(err)
+	}
+	a.users = make(map[string]*user)
+	a.init()
+	a.deliverOutbox()
+	assignment = discord.sent()[len(discord.sent())-1]
+	if assignment.result == nil || assignment.result.title != taskAssignment || !strings.Contains(assignment.result.description, "merge PR") {
+		t.Fatalf("pending assignment not notified again, got %#v", assignment)
+	}
+	discord.injectReaction(assignment.channelID, assignment.id, "200", acceptEmoji)
+	if tasks := a.users["200"].tasks; len(tasks) != 1 || !tasks[0].accepted {
+		t.Errorf("task not accepted on the new notification, got %v", tasks)
+	}
+
+	// Without the user who assigned it, a declined task
+	// becomes a task of the assignee
+	discord.injectMessage(fakeCommandChannel, "100", "!staffme <@200>, fix CI")
+	assignment = discord.sent()[len(discord.sent())-2]
+	delete(a.users, "100")
+	discord.injectReaction(assignment.channelID, assignment.id, "200", declineEmoji)
+	a.users = make(map[string]*user)
+	a.init()
+	if tasks := a.users["200"].tasks; len(tasks) != 2 || tasks[1].name != "fix CI" || tasks[1].assignedBy != "" {
+		t.Errorf("invalid declined task, got %v", tasks)
+	}
+
+	// Assignees are only registered with their task
+	discord.injectMessage(fakeCommandChannel, "100", "!staffme <@400>, fix CI > lint")
+	if _, exist := a.users["400"]; exist {
+		t.Errorf("assignee of a refused task registered")
+	}
+}
+
+func TestListsAndTags(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	inputs := []string{
+		"!staffme write tests #backend, list: release-1.2",
+		"!staffme bump version, 12-01-30, list: release-1.2",
+		"!staffme fix login #backend #urgent",
+		"!staffme update docs",
+	}
+	for _, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != statusOK {
+			t.Fatalf("invalid confirmation for %s, got %#v", input, msg.result)
+		}
+	}
+
+	briefs := []string{"!briefme", "!briefme list release-1.2", "!briefme #backend", "!briefme #backend #urgent"}
+	expects := []string{
+		"== Brief me! ==\nReminders:\n  No active reminders\nTasks:\n  [ ] fix login (#backend #urgent)\n  [ ] update docs\nList release-1.2:\n  [ ] bump version\n  [ ] write tests (#backend)\n",
+		"== Brief me! ==\nList release-1.2:\n  [ ] bump version\n  [ ] write tests (#backend)\n",
+		"== Brief me! ==\nReminders:\n  No active reminders\nTasks:\n  [ ] fix login (#backend #urgent)\nList release-1.2:\n  [ ] write tests (#backend)\n",
+		"== Brief me! ==\nReminders:\n  No active reminders\nTasks:\n  [ ] fix login (#backend #urgent)\n",
+	}
+	for i, input := range briefs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if result := renderText(discord.last().result); result != expects[i] {
+			t.Errorf("invalid brief for %s, expected %q got %q", input, expects[i], result)
+		}
+	}
+
+	// Labels survive a restart
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "100", briefs[0])
+	if result := renderText(discord.last().result); result != expects[0] {
+		t.Errorf("invalid brief after restart, expected %q got %q", expects[0], result)
+	}
+}
+
+func TestPrioritySorting(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	inputs := []string{
+		"!staffme update docs",
+		"!staffme fix login p2",
+		"!staffme ship release !!, 11-01-30",
+		"!staffme write changelog p2, 10-01-30 12:00",
+	}
+	for _, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+	}
+	clock.advance(3 * time.Hour)
+
+	orders := []string{"", "!sortme priority", "!sortme added"}
+	expects := []string{
+		"Tasks:\n  [ ] ! write changelog (p2)\n  [ ] ship release (p1)\n  [ ] fix login (p2)\n  [ ] update docs\n",
+		"Tasks:\n  [ ] ship release (p1)\n  [ ] ! write changelog (p2)\n  [ ] fix login (p2)\n  [ ] update docs\n",
+		"Tasks:\n  [ ] update docs\n  [ ] fix login (p2)\n  [ ] ship release (p1)\n  [ ] ! write changelog (p2)\n",
+	}
+	for i, order := range orders {
+		if order != "" {
+			discord.injectMessage(fakeCommandChannel, "100", order)
+		}
+		discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+		result := renderText(discord.last().result)
+		if !strings.HasSuffix(result, expects[i]) {
+			t.Errorf("invalid brief sorted by %q, expected %q got %q", order, expects[i], result)
+		}
+	}
+
+	// The sort order and priorities survive a restart
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+	if result := renderText(discord.last().result); !strings.HasSuffix(result, expects[2]) {
+		t.Errorf("invalid brief after restart, expected %q got %q", expects[2], result)
+	}
+}
+
+func TestSubtasks(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	inputs := []string{
+		"!staffme release, list: v1",
+		"!staffme release > bump version",
+		"!staffme release > tag",
+		"!staffme release > publish",
+		"!staffme unknown > tag",
+		"!doneme release > bump version",
+		"!removeme task, release > publish",
+	}
+	expects := []resultStatus{statusOK, statusOK, statusOK, statusOK, statusError, statusOK, statusOK}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != expects[i] {
+			t.Fatalf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+
+	discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+	expect := "List v1:\n  [ ] release (1/2)\n  [x] ↳ bump version\n  [ ] ↳ tag\n"
+	if result := renderText(discord.last().result); !strings.HasSuffix(result, expect) {
+		t.Errorf("invalid brief, expected %q got %q", expect, result)
+	}
+
+	// Subtasks are loaded back under their task
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "100", "!doneme release > tag")
+	if msg := discord.last(); msg.result.description != "Subtask has been done, release is at 2/2" {
+		t.Errorf("invalid progress, got %s", msg.result.description)
+	}
+	if task := a.users["100"].tasks[0]; !task.done {
+		t.Errorf("task was not completed with its subtasks, got %#v", task)
+	}
+
+	discord.injectMessage(fakeCommandChannel, "100", "!removeme task, release")
+	if count := countItems(t, a); count != 0 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 0, count)
+	}
+}
+
+func TestPaginatedBrief(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	_, discord, _ := newTestApp(t, start)
+
+	for i := 0; i < 120; i += 1 {
+		input := fmt.Sprintf("!staffme task %03d %s", i, strings.Repeat("long name ", 6))
+		discord.injectMessage(fakeCommandChannel, "100", input)
+	}
+	discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+	brief := discord.last()
+	pages := renderEmbeds(brief.result)
+	if len(pages) < 2 {
+		t.Fatalf("expected a paginated brief, got %d pages", len(pages))
+	}
+	if len(brief.reactions) != 2 || brief.reactions[0] != prevPageEmoji || brief.reactions[1] != nextPageEmoji {
+		t.Errorf("invalid pagination reactions, got %v", brief.reactions)
+	}
+
+	items := 0
+	for _, page := range pages {
+		size := len([]rune(page.Title)) + len([]rune(page.Description)) + len([]rune(page.Footer.Text))
+		for _, field := range page.Fields {
+			if n := len([]rune(field.Value)); n > embedFieldValueLimit {
+				t.Errorf("field %s over the limit with %d characters", field.Name, n)
+			}
+			size += len([]rune(field.Name)) + len([]rune(field.Value))
+			items += strings.Count(field.Value, "\n")
+		}
+		if size > embedTotalLimit || len(page.Fields) > embedFieldsLimit {
+			t.Errorf("page %s over the limits with %d characters", page.Footer.Text, size)
+		}
+	}
+	if items != 120 {
+		t.Errorf("invalid number of items across pages, expected %d got %d", 120, items)
+	}
+
+	footer := func() string {
+		msg, err := discord.getMessage(fakeCommandChannel, brief.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.Embeds[0].Footer.Text
+	}
+	emojis := []string{prevPageEmoji, nextPageEmoji, prevPageEmoji}
+	expects := []int{1, 2, 1}
+	for page := 2; page <= len(pages)+1; page += 1 {
+		emojis = append(emojis, nextPageEmoji)
+		expects = append(expects, page)
+	}
+	// Turning past the last page keeps it
+	expects[len(expects)-1] = len(pages)
+	for i, emoji := range emojis {
+		discord.injectReaction(fakeCommandChannel, brief.id, "100", emoji)
+		expect := fmt.Sprintf("Page %d/%d", expects[i], len(pages))
+		if got := footer(); got != expect {
+			t.Errorf("invalid page after %s, expected %q got %q", emoji, expect, got)
+		}
+	}
+	// Every turn takes the reaction back, the first and last page included
+	if len(brief.removed) != len(emojis) {
+		t.Errorf("invalid removed reactions, expected %d got %v", len(emojis), brief.removed)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// A Thursday
+	start := time.Date(2030, time.January, 10, 7, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	inputs := []string{
+		"!remindme standup, 10-01-30 10:00",
+		"!remindme dentist, 11-01-30 10:00",
+		"!staffme write report, 12-01-30 12:00",
+		"!staffme file taxes, 09-01-30 12:00",
+		"!staffme someday",
+		"!digestme 08:00",
+		"!digestme 25:00",
+		"!digestme 08:00, <@200>",
+	}
+	expects := []resultStatus{statusOK, statusOK, statusOK, statusOK, statusOK, statusOK, statusError, statusError}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != expects[i] {
+			t.Fatalf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+
+	digests := func() []*fakeMessage {
+		var result []*fakeMessage
+		for _, msg := range discord.sent() {
+			if msg.result != nil && strings.HasSuffix(msg.result.title, " digest") {
+				result = append(result, msg)
+			}
+		}
+		return result
+	}
+	discord.tick()
+	if sent := digests(); len(sent) != 0 {
+		t.Fatalf("digest sent before its time")
+	}
+
+	clock.advance(time.Hour)
+	discord.tick()
+	discord.tick()
+	sent := digests()
+	if len(sent) != 1 || sent[0].channelID != "dm-100" {
+		t.Fatalf("expected one digest by direct message, got %d", len(sent))
+	}
+	text := renderText(sent[0].result)
+	for _, line := range []string{"standup", "write report", "! file taxes"} {
+		if !strings.Contains(text, line) {
+			t.Errorf("invalid digest, expected %q in %q", line, text)
+		}
+	}
+	for _, line := range []string{"dentist", "someday"} {
+		if strings.Contains(text, line) {
+			t.Errorf("invalid digest, unexpected %q in %q", line, text)
+		}
+	}
+
+	// Not sent twice, even after a restart
+	a.users = make(map[string]*user)
+	a.init()
+	discord.tick()
+	if sent := digests(); len(sent) != 1 {
+		t.Fatalf("digest sent again after a restart")
+	}
+
+	// Weekly digest on the next Saturday, in a channel
+	discord.injectMessage(fakeCommandChannel, "100", "!digestme 09:30 saturday, <#55>")
+	for i := 0; i < 3*24*4; i += 1 {
+		clock.advance(15 * time.Minute)
+		discord.tick()
+	}
+	sent = digests()
+	if len(sent) != 2 || sent[1].channelID != "55" || sent[1].result.title != "Weekly digest" {
+		t.Fatalf("expected a weekly digest in the channel, got %d digests", len(sent))
+	}
+	if sent[1].result.description != "Saturday 12 January" {
+		t.Errorf("weekly digest sent on %s", sent[1].result.description)
+	}
+
+	discord.injectMessage(fakeCommandChannel, "100", "!digestme off")
+	clock.advance(7 * 24 * time.Hour)
+	discord.tick()
+	if sent := digests(); len(sent) != 2 {
+		t.Errorf("digest sent after being turned off")
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2030, time.January, 10, 21, 0, 0, 0, tokyo)
+	a, discord, clock := newTestApp(t, start)
+	due := func(d time.Duration) string {
+		return start.Add(d).In(time.Local).Format("02-01-06 15:04")
+	}
+
+	inputs := []string{
+		"!quietme 22:00-07:00, Asia/Tokyo",
+		"!quietme 22:00-07:00, Mars/Base",
+		"!remindme call mom, " + due(90*time.Minute),
+		"!remindme server alert !!, " + due(90*time.Minute),
+		"!dnd soon",
+	}
+	expects := []resultStatus{statusOK, statusError, statusOK, statusOK, statusError}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != expects[i] {
+			t.Fatalf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+
+	alarms := func(name string) int {
+		count := 0
+		for _, msg := range discord.sent() {
+			if msg.result != nil && msg.result.title == reminderAlarm && strings.Contains(msg.result.description, name) {
+				count += 1
+			}
+		}
+		return count
+	}
+	held := func() []*fakeMessage {
+		var result []*fakeMessage
+		for _, msg := range discord.sent() {
+			if msg.result != nil && msg.result.title == heldAlarms {
+				result = append(result, msg)
+			}
+		}
+		return result
+	}
+
+	// Until 06:50, only the first alarm of the evening rings,
+	// the urgent item keeps ringing through the night
+	for clock.now().Before(start.Add(9*time.Hour + 50*time.Minute)) {
+		discord.tick()
+		clock.advance(10 * time.Minute)
+	}
+	if n := alarms("call mom"); n != 1 {
+		t.Errorf("expected 1 alarm before the quiet hours, got %d", n)
+	}
+	if n := alarms("server alert"); n < 10 {
+		t.Errorf("expected the urgent item to ring all night, got %d alarms", n)
+	}
+	if len(held()) != 0 {
+		t.Fatalf("held alarms delivered during the quiet hours")
+	}
+
+	clock.advance(10 * time.Minute)
+	discord.tick()
+	batches := held()
+	if len(batches) != 1 {
+		t.Fatalf("expected one batch of held alarms at 07:00, got %d", len(batches))
+	}
+	expect := "While you were away:\n  - Have you done call mom?\n"
+	if text := renderText(batches[0].result); !strings.HasSuffix(text, expect) {
+		t.Errorf("invalid batch, expected %q got %q", expect, text)
+	}
+
+	// The settings survive a restart
+	a.users = make(map[string]*user)
+	a.init()
+	u := a.users["100"]
+	if u.quiet == nil || u.quiet.String() != "22:00-07:00" || u.location().String() != "Asia/Tokyo" {
+		t.Fatalf("quiet hours lost after a restart, got %v in %s", u.quiet, u.location())
+	}
+
+	// Do not disturb holds the alarms until turned off
+	discord.injectMessage(fakeCommandChannel, "100", "!dnd 2h")
+	discord.injectMessage(fakeCommandChannel, "100", "!remindme dentist, "+due(11*time.Hour))
+	discord.tick()
+	if n := alarms("dentist"); n != 0 {
+		t.Errorf("alarm rang during do not disturb")
+	}
+
+	// Held alarms survive a restart and the batch can be acknowledged
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "100", "!dnd off")
+	discord.tick()
+	batches = held()
+	if len(batches) != 2 || !strings.Contains(renderText(batches[1].result), "dentist") {
+		t.Fatalf("held alarms not delivered once do not disturb is off")
+	}
+	discord.injectReaction(batches[1].channelID, batches[1].id, "100", "☑")
+	if findItemByName(a.users["100"].reminders, "dentist") != -1 {
+		t.Errorf("reminder not acknowledged from the batch of held alarms")
+	}
+}
+
+func TestNagPolicy(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	inputs := []string{
+		"!remindme pay rent, 10-01-30 10:30, nag: 10m 30m, max: 3, escalate: <@200> after 2",
+		"!remindme water plants, 10-01-30 10:30, list: home, escalate: dm after 1, max: 1",
+		"!remindme feed cat, 10-01-30 10:30, nag: soon",
+		"!remindme feed cat, 10-01-30 10:30, escalate: <#55>",
+	}
+	expects := []resultStatus{statusOK, statusOK, statusError, statusError}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != expects[i] {
+			t.Fatalf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+
+	count := func(title, name string) (count int, channels []string) {
+		for _, msg := range discord.sent() {
+			if msg.result != nil && msg.result.title == title && strings.Contains(msg.result.description, name) {
+				count += 1
+				channels = append(channels, msg.channelID)
+			}
+		}
+		return
+	}
+	run := func(until time.Time) {
+		for clock.now().Before(until) {
+			clock.advance(5 * time.Minute)
+			discord.tick()
+		}
+	}
+
+	// Past due from the 10:35 tick, nagged at 10:45, 11:15
+	// and 11:45, missed at 12:15
+	run(start.Add(2*time.Hour + 10*time.Minute))
+	if n, _ := count(reminderAlarm, "Have you done **pay rent**"); n != 3 {
+		t.Errorf("expected 3 nags before giving up, got %d", n)
+	}
+	if n, _ := count(reminderMissed, "pay rent"); n != 0 {
+		t.Errorf("reminder missed too early")
+	}
+	run(start.Add(2*time.Hour + 15*time.Minute))
+	if n, _ := count(reminderMissed, "pay rent"); n != 1 {
+		t.Errorf("expected the reminder to be missed, got %d notices", n)
+	}
+	if n, _ := count(reminderEscalated, "pay rent"); n != 1 {
+		t.Errorf("expected a single escalation, got %d", n)
+	}
+	// Both notices can be acknowledged
+	for _, msg := range discord.sent() {
+		if msg.result == nil || (msg.result.title != reminderEscalated && msg.result.title != reminderMissed) {
+			continue
+		}
+		if len(msg.reactions) != 1 || msg.reactions[0] != "☑" || len(a.notifiedItems(msg.id)) != 1 {
+			t.Errorf("expected %s to be acknowledged with ☑, got %v", msg.result.title, msg.reactions)
+		}
+	}
+
+	// The default interval applies without intervals, the first nag
+	// is escalated by direct message
+	_, channels := count(reminderAlarm, "Have you done **water plants**")
+	if len(channels) != 2 || channels[0] != fakeRemindChannel || channels[1] != "dm-100" {
+		t.Errorf("expected a nag escalated by direct message, got %v", channels)
+	}
+
+	// Missed reminders stay silent, even after a restart
+	a.users = make(map[string]*user)
+	a.init()
+	run(start.Add(6 * time.Hour))
+	if n, _ := count(reminderAlarm, "Have you done"); n != 5 {
+		t.Errorf("missed reminders nagged again, got %d nags", n)
+	}
+	discord.injectMessage(fakeCommandChannel, "100", "!briefme")
+	expect := "missed"
+	if text := renderText(discord.last().result); strings.Count(text, expect) != 2 {
+		t.Errorf("expected both reminders missed in %q", text)
+	}
+}
+
+func TestNagQuietHours(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 21, 0, 0, 0, time.UTC)
+	a, discord, clock := newTestApp(t, start)
+	due := func(d time.Duration) string {
+		return start.Add(d).In(time.Local).Format("02-01-06 15:04")
+	}
+
+	inputs := []string{
+		"!quietme 22:00-07:00, UTC",
+		"!remindme pay rent, " + due(40*time.Minute) + ", nag: 10m, max: 1",
+		"!remindme water plants, " + due(50*time.Minute) + ", nag: 10m, max: 2",
+	}
+	for _, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "100", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != statusOK {
+			t.Fatalf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+	count := func(title, name string) (count int) {
+		for _, msg := range discord.sent() {
+			if msg.result != nil && msg.result.title == title && strings.Contains(msg.result.description, name) {
+				count += 1
+			}
+		}
+		return count
+	}
+	run := func(until time.Time) {
+		for clock.now().Before(until) {
+			clock.advance(5 * time.Minute)
+			discord.tick()
+		}
+	}
+
+	// pay rent is nagged once at 21:55, the nags of the night are held
+	// and neither counted nor missed
+	run(start.Add(9*time.Hour + 55*time.Minute))
+	if n := count(reminderAlarm, "Have you done **pay rent**"); n != 1 {
+		t.Errorf("expected 1 nag before the quiet hours, got %d", n)
+	}
+	if n := count(reminderAlarm, "Have you done **water plants**"); n != 0 {
+		t.Errorf("nags sent during the quiet hours, got %d", n)
+	}
+	if n := count(reminderMissed, ""); n != 0 {
+		t.Errorf("reminders missed during the quiet hours, got %d notices", n)
+	}
+	u := a.users["100"]
+	if index := findItemByName(u.reminders, "water plants"); u.reminders[index].nagCount != 0 {
+		t.Errorf("held nags counted, got %d", u.reminders[index].nagCount)
+	}
+
+	// From 07:00, water plants is nagged twice before being missed
+	run(start.Add(10*time.Hour + 25*time.Minute))
+	if n := count(reminderMissed, "pay rent"); n != 1 {
+		t.Errorf("expected pay rent to be missed once the quiet hours end, got %d notices", n)
+	}
+	if n := count(reminderAlarm, "Have you done **water plants**"); n != 2 {
+		t.Errorf("expected 2 nags after the quiet hours, got %d", n)
+	}
+	if n := count(reminderMissed, "water plants"); n != 1 {
+		t.Errorf("expected water plants to be missed, got %d notices", n)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+	queued := func(table string) int {
+		d, err := a.db.QueryDocument(fmt.Sprintf("SELECT COUNT(*) FROM %s;", table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		if err := document.Scan(d, &count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// A failed alarm is retried after a backoff, the embed
+	// waits for the ping sent before it
+	discord.injectMessage(fakeCommandChannel, "100", "!remindme standup, 10-01-30 11:30")
+	discord.failNext(1)
+	discord.tick()
+	if n := len(discord.sent()); n != 1 || queued("outbox") != 2 {
+		t.Fatalf("expected the alarm to stay queued, got %d messages and %d queued", n, queued("outbox"))
+	}
+	discord.tick()
+	if queued("outbox") != 2 {
+		t.Fatalf("alarm retried before its backoff")
+	}
+	clock.advance(outboxBaseBackoff)
+	discord.tick()
+	sent := discord.sent()
+	if len(sent) != 3 || sent[1].content != "<@100>" || sent[2].result.title != reminderAlarm {
+		t.Fatalf("expected the ping then the alarm, got %d messages", len(sent))
+	}
+	if queued("outbox") != 0 {
+		t.Errorf("delivered messages left in the outbox")
+	}
+
+	// A message failing every attempt is dead-lettered
+	// and stops holding back its channel
+	discord.failNext(outboxMaxAttempts)
+	a.enqueue(textMessage("55", "lost"), textMessage("55", "next"))
+	for i := 0; i < outboxMaxAttempts; i += 1 {
+		a.deliverOutbox()
+		clock.advance(outboxMaxBackoff)
+	}
+	a.deliverOutbox()
+	if queued("outbox_dead_letters") != 1 || queued("outbox") != 0 {
+		t.Errorf("expected one dead letter, got %d and %d queued", queued("outbox_dead_letters"), queued("outbox"))
+	}
+	if last := discord.last(); last.content != "next" {
+		t.Errorf("expected the next message to be sent, got %q", last.content)
+	}
+
+	// Messages over the channel rate wait for the next window
+	a.config.Outbox.ChannelRate = 2
+	for i := 0; i < 5; i += 1 {
+		a.enqueue(textMessage("66", fmt.Sprintf("message %d", i)))
+	}
+	for _, expect := range []int{2, 4, 5} {
+		a.deliverOutbox()
+		count := 0
+		for _, msg := range discord.sent() {
+			if msg.channelID == "66" {
+				count += 1
+			}
+		}
+		if count != expect {
+			t.Errorf("expected %d messages within the rate, got %d", expect, count)
+		}
+		clock.advance(outboxRateWindow)
+	}
+}
+
+func TestStalledDiscord(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	// The outbox worker waits on Discord to send the confirmation
+	release := discord.stall()
+	discord.post(fakeCommandChannel, "alice", "!remindme standup, 10-01-30 10:20")
+	delivered := make(chan bool)
+	go func() {
+		a.deliverOutbox()
+		delivered <- true
+	}()
+
+	// Meanwhile the other users and the scheduler are served
+	served := make(chan bool)
+	go func() {
+		discord.post(fakeCommandChannel, "bob", "!remindme lunch, 10-01-30 15:00")
+		a.tick()
+		served <- true
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		release()
+		t.Fatal("commands and alarms stalled behind a pending send")
+	}
+	release()
+	<-delivered
+	a.deliverOutbox()
+
+	var confirmations, alarms int
+	for _, msg := range discord.sent() {
+		switch {
+		case msg.result == nil:
+		case msg.result.title == "Remind me!":
+			confirmations += 1
+		case msg.result.title == reminderAlarm:
+			alarms += 1
+		}
+	}
+	if confirmations != 2 || alarms != 1 {
+		t.Errorf("expected 2 confirmations and 1 alarm, got %d and %d", confirmations, alarms)
+	}
+}
+
+func TestSlowAPIClient(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+	discord.post(fakeCommandChannel, "alice", "!briefme")
+	a.config.API.Token = "secret"
+
+	// The client is still sending the body of its request
+	body, client := io.Pipe()
+	r := httptest.NewRequest(http.MethodPost, "/api/users/alice/items", body)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	created := make(chan bool)
+	go func() {
+		a.serveAPI(w, r)
+		created <- true
+	}()
+
+	// Meanwhile the users and the scheduler are served
+	served := make(chan bool)
+	go func() {
+		discord.post(fakeCommandChannel, "bob", "!remindme lunch, 10-01-30 15:00")
+		a.tick()
+		served <- true
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		client.Close()
+		t.Fatal("commands and alarms stalled behind a slow API client")
+	}
+	io.WriteString(client, `{"kind": "task", "name": "write docs"}`)
+	client.Close()
+	<-created
+	if w.Code != http.StatusCreated || len(a.users["alice"].tasks) != 1 {
+		t.Errorf("invalid item creation, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// Meant to run with the race detector: go test -race
+func TestConcurrentCommands(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+	const users, reminders = 6, 8
+
+	// The scheduler fires alarms while every user registers reminders
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 30; i += 1 {
+			clock.advance(time.Minute)
+			discord.tick()
+		}
+	}()
+	for u := 0; u < users; u += 1 {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			for i := 0; i < reminders; i += 1 {
+				discord.injectMessage(fakeCommandChannel, userID, fmt.Sprintf("!remindme %s chore %d, 10-01-30 12:%02d", userID, i, i))
+				discord.injectMessage(fakeCommandChannel, userID, "!briefme")
+			}
+		}(fmt.Sprintf("u%d", u))
+	}
+	wg.Wait()
+
+	if count := countItems(t, a); count != users*reminders {
+		t.Fatalf("invalid number of items in database, expected %d got %d", users*reminders, count)
+	}
+	for id, u := range a.users {
+		if len(u.reminders) != reminders {
+			t.Errorf("expected %d reminders for %s, got %d", reminders, id, len(u.reminders))
+		}
+	}
+
+	// Every user acknowledges their nags while the scheduler runs
+	clock.advance(3 * time.Hour)
+	discord.tick()
+	clock.advance(time.Duration(a.config.ReminderFrequency) * time.Minute)
+	discord.tick()
+	// A ping and an embed per nag take more than one outbox batch
+	for i := 0; i < 2*users*reminders/outboxBatchSize; i += 1 {
+		a.deliverOutbox()
+	}
+	nags := make(map[string][]*fakeMessage)
+	for _, msg := range discord.sent() {
+		if msg.result != nil && strings.HasPrefix(msg.result.description, "Have you done **") {
+			owner := strings.Fields(strings.TrimPrefix(msg.result.description, "Have you done **"))[0]
+			nags[owner] = append(nags[owner], msg)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i += 1 {
+			clock.advance(time.Second)
+			discord.tick()
+		}
+	}()
+	for owner, messages := range nags {
+		wg.Add(1)
+		go func(owner string, messages []*fakeMessage) {
+			defer wg.Done()
+			for _, msg := range messages {
+				discord.injectReaction(msg.channelID, msg.id, owner, "☑")
+			}
+		}(owner, messages)
+	}
+	wg.Wait()
+
+	if count := countItems(t, a); count != 0 {
+		t.Errorf("invalid number of items in database, expected %d got %d", 0, count)
+	}
+	for id, u := range a.users {
+		if len(u.reminders) != 0 {
+			t.Errorf("expected every reminder of %s to be acknowledged, got %d", id, len(u.reminders))
+		}
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	staging, stagingDiscord, _ := newTestApp(t, start)
+	prod, prodDiscord, _ := newTestApp(t, start)
+
+	// Each bot only sees the commands of its own session
+	var wg sync.WaitGroup
+	for _, bot := range []*fakeDiscord{stagingDiscord, prodDiscord} {
+		wg.Add(1)
+		go func(bot *fakeDiscord) {
+			defer wg.Done()
+			bot.injectMessage(fakeCommandChannel, "alice", "!remindme standup, 10-01-30 11:30")
+		}(bot)
+	}
+	wg.Wait()
+	prodDiscord.injectMessage(fakeCommandChannel, "alice", "!staffme deploy")
+
+	if count := countItems(t, staging); count != 1 {
+		t.Errorf("invalid number of items in staging, expected %d got %d", 1, count)
+	}
+	if count := countItems(t, prod); count != 2 {
+		t.Errorf("invalid number of items in prod, expected %d got %d", 2, count)
+	}
+	if len(staging.users["alice"].tasks) != 0 || len(prod.users["alice"].tasks) != 1 {
+		t.Errorf("task added to the wrong instance")
+	}
+	if n := len(stagingDiscord.sent()); n != 1 {
+		t.Errorf("expected 1 message on staging, got %d", n)
+	}
+}
+
+func TestCommandContext(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	ctx := a.newContext(&discordgo.Message{
+		GuildID:   "guild",
+		ChannelID: "general",
+		Author:    &discordgo.User{ID: "alice", Locale: "fr"},
+		Member:    &discordgo.Member{Roles: []string{"ops"}},
+	})
+	if ctx.guildID != "guild" || ctx.channelID != "general" || ctx.locale != "fr" || ctx.message == nil || len(ctx.roles) != 1 || ctx.clock != clock {
+		t.Errorf("invalid context, got %+v", ctx)
+	}
+	if ctx := a.newContext(nil); ctx.channelID != "" || ctx.message != nil || ctx.locale != defaultLocale || ctx.app != a {
+		t.Errorf("invalid API context, got %+v", ctx)
+	}
+
+	// Items remember the channel they were created in
+	discord.injectMessage("general", "alice", "!remindme standup, 10-01-30 11:30")
+	discord.injectMessage("dev", "alice", "!staffme write docs")
+	discord.injectMessage("dev", "alice", "!staffme write docs > api")
+	a.users = make(map[string]*user)
+	a.init()
+	u := a.users["alice"]
+	if channel := u.reminders[0].channelID; channel != "general" {
+		t.Errorf("invalid reminder channel, expected general got %q", channel)
+	}
+	if channel := u.tasks[0].subtasks[0].channelID; channel != "dev" {
+		t.Errorf("invalid subtask channel, expected dev got %q", channel)
+	}
+	if item := makeAPIItem(&u.tasks[0]); item.Channel != "dev" {
+		t.Errorf("invalid API channel, expected dev got %q", item.Channel)
+	}
+}
+
+func TestHelpMe(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	_, discord, _ := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "alice", "!helpme")
+	if result := discord.last().result; len(result.sections) != len(commands.specs) || result.sections[0].name != "`!briefme`" {
+		t.Errorf("expected a section per command, got %#v", result.sections)
+	}
+
+	discord.injectMessage(fakeCommandChannel, "alice", "!helpme RemindMe")
+	result := discord.last().result
+	if !strings.HasPrefix(result.description, "`!remindme` ") || len(result.sections) != 2 || result.sections[1].name != "Examples" {
+		t.Errorf("invalid command help, got %#v", result)
+	}
+
+	discord.injectMessage(fakeCommandChannel, "alice", "!helpme listme")
+	if result := discord.last().result; result.status != statusError {
+		t.Errorf("expected an error for an unknown command, got %#v", result)
+	}
+}
+
+func TestGuildSettings(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "alice", "!Configure prefix ?")
+	discord.injectMessage(fakeCommandChannel, "alice", "?configure alias R remindme")
+	if result := discord.last().result; result.status != statusOK || result.sections[0].items[0].name != "?r stands for ?remindme" {
+		t.Fatalf("invalid settings, got %#v", result)
+	}
+
+	// Other bots keep the bang, a mention of the bot always works
+	sentBefore := len(discord.sent())
+	discord.injectMessage(fakeCommandChannel, "alice", "!briefme")
+	if sent := len(discord.sent()); sent != sentBefore {
+		t.Errorf("message without the prefix was answered")
+	}
+	inputs := []string{
+		"?r standup, 10-01-30 11:30",
+		"?BRIEFME",
+		"<@" + fakeBotID + "> briefme",
+		"?configure alias briefme helpme",
+		"?configure alias b listme",
+	}
+	expects := []resultStatus{statusOK, statusOK, statusOK, statusError, statusError}
+	for i, input := range inputs {
+		discord.injectMessage(fakeCommandChannel, "alice", input)
+		if msg := discord.last(); msg.result == nil || msg.result.status != expects[i] {
+			t.Errorf("invalid result for %s, got %#v", input, msg.result)
+		}
+	}
+	if len(a.users["alice"].reminders) != 1 {
+		t.Errorf("reminder was not added through the alias")
+	}
+	discord.injectMessage(fakeCommandChannel, "alice", "?helpme R")
+	if result := discord.last().result; !strings.HasPrefix(result.description, "`!remindme` ") {
+		t.Errorf("expected the help of the aliased command, got %#v", result)
+	}
+
+	// The settings survive a restart, and only exist in servers
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "alice", "?configure alias r off")
+	if result := discord.last().result; result.status != statusOK || len(result.sections[0].items) != 0 || !strings.Contains(result.description, "`?`") {
+		t.Errorf("invalid settings after restart, got %#v", result)
+	}
+	a.handleMessage(&discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ChannelID: "dm-alice",
+			Content:   "!configure prefix ?",
+			Author:    &discordgo.User{ID: "alice", Username: "alice"},
+		},
+	})
+	a.deliverOutbox()
+	if result := discord.last().result; result.status != statusError {
+		t.Errorf("expected settings to be refused outside servers, got %#v", result)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, clock := newTestApp(t, start)
+
+	discord.injectMessage(fakeCommandChannel, "100", "!configure role <@&7> settings assign acknowledge", "7")
+	discord.injectMessage(fakeCommandChannel, "100", "!configure role <@&8> settings", "7")
+	if result := discord.last().result; result.status != statusOK || len(result.sections[1].items) != 2 {
+		t.Fatalf("invalid roles, got %#v", result)
+	}
+
+	type input struct {
+		author, content string
+		roles           []string
+		expect          resultStatus
+	}
+	inputs := []input{
+		{"100", "!configure role <@&7> assign acknowledge", []string{"7"}, statusError},
+		{"200", "!configure prefix ?", nil, statusError},
+		{"200", "!configure", nil, statusOK},
+		{"200", "!staffme <@100>, review the docs", nil, statusError},
+		{"200", "!staffme <@200>, review the docs", nil, statusOK},
+		{"100", "!staffme <@300>, write tests", []string{"7"}, statusOK},
+		{"300", "!remindme water plants, 10-01-30 10:30", nil, statusOK},
+		{"200", "!remindme water plants, 11-01-30 12:00", nil, statusOK},
+	}
+	for _, in := range inputs {
+		discord.injectMessage(fakeCommandChannel, in.author, in.content, in.roles...)
+		if result := discord.last().result; result == nil || result.status != in.expect {
+			t.Errorf("invalid result for %s by %s, got %#v", in.content, in.author, result)
+		}
+	}
+	if _, exist := a.users["100"]; exist && len(a.users["100"].tasks) != 0 {
+		t.Errorf("task was assigned without the capability, got %v", a.users["100"].tasks)
+	}
+
+	var assignment *fakeMessage
+	for _, msg := range discord.sent() {
+		if msg.result != nil && msg.result.title == taskAssignment {
+			assignment = msg
+		}
+	}
+	discord.injectReaction(assignment.channelID, assignment.id, "200", acceptEmoji, "7")
+	if tasks := a.users["300"].tasks; len(tasks) != 1 || tasks[0].accepted {
+		t.Fatalf("assignment was answered by someone else, got %v", tasks)
+	}
+	discord.injectReaction(assignment.channelID, assignment.id, "300", acceptEmoji)
+	if tasks := a.users["300"].tasks; len(tasks) != 1 || !tasks[0].accepted {
+		t.Fatalf("assignment was not accepted, got %v", tasks)
+	}
+
+	clock.advance(31 * time.Minute)
+	discord.tick()
+	clock.advance(time.Duration(a.config.ReminderFrequency) * time.Minute)
+	discord.tick()
+	var nag *fakeMessage
+	for _, msg := range discord.sent() {
+		if msg.result != nil && msg.result.title == reminderAlarm {
+			nag = msg
+		}
+	}
+	if nag == nil {
+		t.Fatal("reminder was not nagged")
+	}
+
+	// The reminder of 200 with the same name is not the one notified
+	discord.injectReaction(nag.channelID, nag.id, "200", "☑")
+	if len(a.users["300"].reminders) != 1 || len(a.users["200"].reminders) != 1 {
+		t.Fatalf("reminders were acknowledged without the capability, got %v and %v", a.users["300"].reminders, a.users["200"].reminders)
+	}
+	discord.injectReaction(nag.channelID, nag.id, "100", "☑", "7")
+	if len(a.users["300"].reminders) != 0 || len(a.users["200"].reminders) != 1 {
+		t.Errorf("reminder was not acknowledged for 300, got %v and %v", a.users["300"].reminders, a.users["200"].reminders)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2030, time.January, 10, 10, 0, 0, 0, time.Local)
+	a, discord, _ := newTestApp(t, start)
+
+	status := func(author, content string) resultStatus {
+		discord.injectMessage(fakeCommandChannel, author, content)
+		return discord.last().result.status
+	}
+
+	// Each undo goes one change further back
+	status("100", "!remindme pick up the milk, 10-01-30 18:00")
+	status("100", "!removeme reminder, pick up the milk")
+	if status("100", "!undome") != statusOK || len(a.users["100"].reminders) != 1 || countItems(t, a) != 1 {
+		t.Fatalf("removed reminder was not restored, got %v", a.users["100"].reminders)
+	}
+	if status("100", "!undome") != statusOK || len(a.users["100"].reminders) != 0 || countItems(t, a) != 0 {
+		t.Fatalf("created reminder was not removed, got %v", a.users["100"].reminders)
+	}
+	if status("100", "!undome") != statusError {
+		t.Errorf("expected nothing left to undo")
+	}
+
+	status("100", "!staffme write tests")
+	status("100", "!staffme write tests > parser")
+	status("100", "!doneme write tests > parser")
+	if task := a.users["100"].tasks[0]; !task.done {
+		t.Fatalf("task was not done with its subtask, got %v", task)
+	}
+	status("100", "!undome")
+	if task := a.users["100"].tasks[0]; task.done || len(task.subtasks) != 1 || task.subtasks[0].done {
+		t.Errorf("subtask was not open again, got %v", task)
+	}
+
+	// The change of someone else is never undone
+	status("100", "!staffme <@200>, review the docs")
+	assignment := discord.sent()[len(discord.sent())-2]
+	discord.injectReaction(assignment.channelID, assignment.id, "200", acceptEmoji)
+	if status("100", "!undome") != statusError || len(a.users["200"].tasks) != 1 {
+		t.Errorf("undo overwrote the change of someone else, got %v", a.users["200"].tasks)
+	}
+
+	// A restored item keeps its notifications
+	status("100", "!remindme call bob, 10-01-30 11:00")
+	discord.tick()
+	alarm := discord.sent()[len(discord.sent())-1]
+	status("100", "!removeme reminder, call bob")
+	status("100", "!undome")
+	discord.injectReaction(alarm.channelID, alarm.id, "100", "☑")
+	if index := findItemByName(a.users["100"].reminders, "call bob"); alarm.result.title != reminderAlarm || index != -1 {
+		t.Errorf("restored reminder not acknowledged on its alarm, got %v", a.users["100"].reminders)
+	}
+
+	// The history survives a restart, and outlives the item
+	a.users = make(map[string]*user)
+	a.init()
+	discord.injectMessage(fakeCommandChannel, "100", "!historyme review the docs")
+	result := discord.last().result
+	if result.status != statusOK || len(result.sections[0].items) != 2 || !strings.Contains(result.sections[0].items[1].name, "edited by <@200>: accepted false → true") {
+		t.Errorf("invalid history, got %#v", result)
+	}
+	discord.injectMessage(fakeCommandChannel, "100", "!historyme pick up the milk")
+	var changes []string
+	for _, line := range discord.last().result.sections[0].items {
+		changes = append(changes, strings.Fields(line.name)[5])
+	}
+	if strings.Join(changes, " ") != "created deleted undone undone" {
+		t.Errorf("invalid timeline, got %v", changes)
+	}
+}
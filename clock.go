@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+type (
+	// Every time based decision of the app goes through its clock
+	// so it can be driven by hand in tests
+	clock interface {
+		now() time.Time
+	}
+
+	realClock struct{}
+)
+
+func (realClock) now() time.Time { return time.Now() }
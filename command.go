@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
@@ -10,211 +11,608 @@ import (
 
 type (
 	command interface {
-		getKind() commandKind
 		String() string
-		execute(u *user) (confirmation *discordgo.MessageEmbed, it *item)
+		execute(ctx *commandContext, u *user) (result *commandResult, it *item)
 	}
 
-	commandKind int
-)
+	// Command persisting what it changed once executed,
+	// it is given the item returned by execute
+	committer interface {
+		// Returns the result replacing the one of execute when
+		// the changes could not be persisted, nil otherwise
+		commit(ctx *commandContext, u *user, it *item) (failure *commandResult)
+	}
 
-const (
-	commandInvalid commandKind = iota
-	commandBriefMe
-	commandRemindMe
-	commandStaffMe
-	commandRemoveMe
-	commandHelpMe
-)
+	// Where and by whom a command runs, bound to the app running it
+	commandContext struct {
+		app   *app
+		clock clock
 
-var commandKeywords = map[string]commandKind{
-	"briefme":  commandBriefMe,
-	"remindme": commandRemindMe,
-	"staffme":  commandStaffMe,
-	"removeme": commandRemoveMe,
-	"helpme":   commandHelpMe,
-}
+		// Empty for commands that do not come from Discord
+		guildID   string
+		channelID string
+		message   *discordgo.Message
+		// Roles of the author in the guild
+		roles []string
+		// Locale of the author, defaultLocale when it is unknown
+		locale string
+	}
+)
 
 type (
 	briefMeCommand struct {
-		kind     commandKind
 		token    token
 		cmdToken token
+		list     string
+		tags     []string
 	}
 
 	remindMeCommand struct {
-		kind       commandKind
 		token      token
 		cmdToken   token
+		targets    []mention
+		identifier string
+		tags       []string
+		priority   priority
+		sepToken   token
+		date       date
+		list       string
+		nag        *nagPolicy
+	}
+
+	remindUsCommand struct {
+		token      token
+		cmdToken   token
+		targets    []mention
 		identifier string
+		tags       []string
+		priority   priority
 		sepToken   token
 		date       date
+		list       string
+		nag        *nagPolicy
 	}
 
 	staffMeCommand struct {
-		kind       commandKind
 		token      token
 		cmdToken   token
+		targets    []mention
+		parent     string
 		identifier string
+		tags       []string
+		priority   priority
 		sepToken   token
 		hasDueDate bool
 		date       date
+		list       string
+		assignee   *user
+		// The assignee never wrote to the bot, registered on commit
+		placeholder bool
+	}
+
+	reassignMeCommand struct {
+		token       token
+		cmdToken    token
+		targets     []mention
+		identifier  string
+		assignee    *user
+		placeholder bool
+		// The task before it was handed over
+		before *itemSnapshot
+	}
+
+	briefTeamCommand struct {
+		token    token
+		cmdToken token
+	}
+
+	sortMeCommand struct {
+		token    token
+		cmdToken token
+		order    sortOrder
+	}
+
+	digestMeCommand struct {
+		token    token
+		cmdToken token
+		off      bool
+		schedule digestSchedule
+	}
+
+	quietMeCommand struct {
+		token    token
+		cmdToken token
+		off      bool
+		hours    quietHours
+		timeZone *time.Location
+	}
+
+	dndCommand struct {
+		token    token
+		cmdToken token
+		off      bool
+		duration time.Duration
+	}
+
+	configureCommand struct {
+		token    token
+		cmdToken token
+		// configurePrefix, configureAlias, configureRole,
+		// or empty to display the settings
+		setting string
+		// The prefix, the name of the alias or the ID of the role
+		value string
+		// Command of the alias, empty to remove it
+		command string
+		// Granted to the role, none to revoke them
+		capabilities []capability
 	}
 
 	removeMeCommand struct {
-		kind       commandKind
 		token      token
 		cmdToken   token
 		list       token
 		sepToken   token
+		parent     string
 		identifier string
 	}
 
+	doneMeCommand struct {
+		token      token
+		cmdToken   token
+		parent     string
+		identifier string
+		// The task before it was done
+		before *itemSnapshot
+	}
+
 	helpMeCommand struct {
-		kind     commandKind
 		token    token
 		cmdToken token
+		// Name of the command to detail, empty for all
+		topic string
+	}
+
+	undoMeCommand struct {
+		token    token
+		cmdToken token
+	}
+
+	historyMeCommand struct {
+		token      token
+		cmdToken   token
+		parent     string
+		identifier string
+	}
+
+	importMeCommand struct {
+		token      token
+		cmdToken   token
+		files      []importFile
+		imported   []item
+		skipped    []string
+		duplicated []string
 	}
 )
 
-func (b *briefMeCommand) getKind() commandKind { return b.kind }
-func (b *briefMeCommand) String() string       { return "Brief me!" }
-func (b *briefMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed, it *item) {
-	confirmation = &discordgo.MessageEmbed{
-		Type:  discordgo.EmbedTypeRich,
-		Title: b.String(),
-	}
-	briefBuilder := strings.Builder{}
-
-	if len(u.reminders) > 0 {
-		for _, reminder := range u.reminders {
-			briefBuilder.WriteString(":small_orange_diamond: **")
-			briefBuilder.WriteString(reminder.name)
-			briefBuilder.WriteString("**  ||  ")
-			briefBuilder.WriteString(reminder.dueTime.Format(timeFormat))
-			briefBuilder.WriteString("\n  ")
-		}
-		confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-			Name:  fmt.Sprintf("%s **Reminders:**", bellEmote),
-			Value: strings.Clone(briefBuilder.String()),
-		})
-	} else {
-		confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-			Name:  fmt.Sprintf("%s **Reminders:**", bellEmote),
-			Value: "No active reminders",
-		})
+// Failure of a commit that could not store the item of the command
+func saveFailed(cmd command, kind string) *commandResult {
+	return &commandResult{
+		title:       cmd.String(),
+		description: fmt.Sprintf("%s could not be saved, nothing has been added", kind),
+		status:      statusError,
 	}
-	briefBuilder.Reset()
+}
 
-	if len(u.tasks) > 0 {
-		for _, task := range u.tasks {
-			if task.done {
-				briefBuilder.WriteString(todoCheckEmote)
-			} else {
-				briefBuilder.WriteString(todoUncheckEmote)
+func (b *briefMeCommand) String() string { return "Brief me!" }
+func (b *briefMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: b.String(),
+	}
+	now := ctx.clock.now()
+	reminders := b.filter(u.reminders)
+	tasks := b.filter(u.tasks)
+	sortItems(reminders, u.sortOrder, now)
+	sortItems(tasks, u.sortOrder, now)
+
+	// Items without a list first, then one section per list
+	if b.list == "" {
+		result.addSection("Reminders", iconReminders, briefItems(inList(reminders, ""), now), "No active reminders")
+		result.addSection("Tasks", iconTasks, briefItems(inList(tasks, ""), now), "No active tasks")
+	}
+	for _, list := range listNames(reminders, tasks) {
+		items := append(briefItems(inList(reminders, list), now), briefItems(inList(tasks, list), now)...)
+		result.addSection("List "+list, iconTasks, items, "")
+	}
+
+	var delegated []resultItem
+	for _, other := range sortedUsers(ctx.app.users) {
+		for _, task := range b.filter(other.tasks) {
+			if task.assignedBy != u.id || task.done {
+				continue
 			}
-			briefBuilder.WriteString(" **")
-			briefBuilder.WriteString(task.name)
-			briefBuilder.WriteString("**\n  ")
-		}
-		confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-			Name:  fmt.Sprintf("%s **Tasks:**", todoEmote),
-			Value: strings.Clone(briefBuilder.String()),
-		})
-	} else {
-		confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-			Name:  fmt.Sprintf("%s **Tasks:**", todoEmote),
-			Value: "No active tasks",
-		})
+			detail := fmt.Sprintf("to <@%s>", other.id)
+			if !task.accepted {
+				detail += ", pending"
+			}
+			delegated = append(delegated, resultItem{
+				mark:   markTodo,
+				name:   task.name,
+				detail: tagsDetail(detail, &task),
+			})
+		}
+	}
+	if len(delegated) > 0 {
+		result.addSection("Assigned to others", iconTasks, delegated, "")
+	}
+
+	if len(result.sections) == 0 {
+		result.description = "No matching items"
 	}
-	briefBuilder.Reset()
 	return
 }
 
-func (r *remindMeCommand) getKind() commandKind { return r.kind }
-func (r *remindMeCommand) String() string       { return "Remind me!" }
-func (r *remindMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed, it *item) {
+// Keeps the items matching the list and every tag of the filters
+func (b *briefMeCommand) filter(items []item) []item {
+	result := make([]item, 0, len(items))
+	for _, it := range items {
+		if b.list != "" && !strings.EqualFold(it.list, b.list) {
+			continue
+		}
+		matches := true
+		for _, tag := range b.tags {
+			matches = matches && it.hasTag(tag)
+		}
+		if matches {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+func inList(items []item, list string) []item {
+	result := make([]item, 0, len(items))
+	for _, it := range items {
+		if it.list == list {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+// Overdue items are highlighted
+func briefItems(items []item, now time.Time) []resultItem {
+	result := make([]resultItem, 0, len(items))
+	for i := range items {
+		it := &items[i]
+		entry := resultItem{
+			name:      it.name,
+			highlight: it.isOverdue(now),
+		}
+		var details []string
+		switch it.kind {
+		case itemReminder:
+			entry.mark = markBullet
+			details = append(details, it.dueTime.Format(timeFormat))
+			if it.missed {
+				details = append(details, "missed")
+			}
+			if len(it.recipients) > 0 {
+				details = append(details, "for "+recipientsString(it.recipients))
+			}
+		case itemTask:
+			entry.mark = markTodo
+			if it.done {
+				entry.mark = markDone
+			}
+			details = append(details, assignmentDetail(it))
+			if len(it.subtasks) > 0 {
+				done, total := subtaskProgress(it)
+				details = append(details, fmt.Sprintf("%d/%d", done, total))
+			}
+		}
+		details = append(details, it.priority.String())
+		entry.detail = tagsDetail(joinDetails(details), it)
+		result = append(result, entry)
+
+		for _, subtask := range briefItems(it.subtasks, now) {
+			subtask.name = "↳ " + subtask.name
+			result = append(result, subtask)
+		}
+	}
+	return result
+}
+
+func joinDetails(details []string) string {
+	nonEmpty := make([]string, 0, len(details))
+	for _, detail := range details {
+		if detail != "" {
+			nonEmpty = append(nonEmpty, detail)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
+
+func (r *remindMeCommand) String() string { return "Remind me!" }
+func (r *remindMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	it = addReminder(ctx, u, r.identifier, r.date, r.targets)
+	it.tags, it.list, it.priority, it.nag = r.tags, r.list, r.priority, r.nag
+
+	result = &commandResult{
+		title:       r.String(),
+		description: "Reminder has been added",
+	}
+	if len(r.targets) > 0 {
+		result.description = fmt.Sprintf("Reminder has been added for %s", recipientsString(it.recipients))
+	}
+	if r.nag != nil {
+		result.description += ", " + r.nag.String()
+	}
+	return
+}
+
+func (r *remindMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if it != nil && !ctx.app.createItem(u, u, it) {
+		return saveFailed(r, "Reminder")
+	}
+	return nil
+}
+
+func (r *remindUsCommand) String() string { return "Remind us!" }
+func (r *remindUsCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	author := mention{kind: mentionUser, id: u.id}
+	targets := append([]mention{author}, r.targets...)
+	it = addReminder(ctx, u, r.identifier, r.date, targets)
+	it.tags, it.list, it.priority, it.nag = r.tags, r.list, r.priority, r.nag
+
+	result = &commandResult{
+		title:       r.String(),
+		description: fmt.Sprintf("Reminder has been added for %s", recipientsString(it.recipients)),
+	}
+	if r.nag != nil {
+		result.description += ", " + r.nag.String()
+	}
+	return
+}
+
+func (r *remindUsCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if it != nil && !ctx.app.createItem(u, u, it) {
+		return saveFailed(r, "Reminder")
+	}
+	return nil
+}
+
+func addReminder(ctx *commandContext, u *user, identifier string, d date, targets []mention) *item {
 	u.reminders = append(u.reminders, item{
-		id:         theApp.genItemID(),
-		name:       r.identifier,
+		id:         ctx.app.genItemID(),
+		name:       identifier,
 		kind:       itemReminder,
+		channelID:  ctx.channelID,
 		hasDueDate: true,
 		dueTime: time.Date(
-			r.date.year, r.date.month, r.date.day,
-			r.date.hour, r.date.min, 0, 0, time.Local,
+			d.year, d.month, d.day,
+			d.hour, d.min, 0, 0, time.Local,
 		),
 		alarmCount: 0,
 		done:       false,
+		recipients: makeRecipients(targets),
 	})
-	it = &u.reminders[len(u.reminders)-1]
+	return &u.reminders[len(u.reminders)-1]
+}
 
-	confirmation = &discordgo.MessageEmbed{
-		Type:        discordgo.EmbedTypeRich,
-		Title:       r.String(),
-		Description: "Reminder has been added",
+func (s *staffMeCommand) String() string { return "Staff me!" }
+func (s *staffMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title:       s.String(),
+		description: "Task has been added",
 	}
-	return
-}
 
-func (s *staffMeCommand) getKind() commandKind { return s.kind }
-func (s *staffMeCommand) String() string       { return "Staff me!" }
-func (s *staffMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed, it *item) {
-	u.tasks = append(u.tasks, item{
-		id:         theApp.genItemID(),
+	owner := u
+	if len(s.targets) > 0 {
+		var registered bool
+		var err error
+		owner, registered, err = resolveAssignee(ctx, s.targets)
+		if err != nil {
+			result.status = statusError
+			result.description = err.Error()
+			return
+		}
+		s.placeholder = !registered
+	}
+
+	task := item{
+		id:         ctx.app.genItemID(),
 		name:       s.identifier,
 		kind:       itemTask,
+		channelID:  ctx.channelID,
 		hasDueDate: s.hasDueDate,
 		done:       false,
-	})
-	it = &u.tasks[len(u.tasks)-1]
+		list:       s.list,
+		tags:       s.tags,
+		priority:   s.priority,
+	}
 	if s.hasDueDate {
-		it.dueTime = time.Date(
+		task.dueTime = time.Date(
 			s.date.year, s.date.month, s.date.day,
 			s.date.hour, s.date.min, 0, 0, time.Local,
 		)
 	}
 
-	confirmation = &discordgo.MessageEmbed{
-		Type:        discordgo.EmbedTypeRich,
-		Title:       s.String(),
-		Description: "Task has been added",
+	if s.parent != "" {
+		index := findItemByName(u.tasks, s.parent)
+		switch {
+		case owner != u:
+			result.status = statusError
+			result.description = "A subtask cannot be assigned, assign its task instead"
+		case index == -1:
+			result.status = statusError
+			result.description = fmt.Sprintf("task %s does not exist", s.parent)
+		default:
+			parent := &u.tasks[index]
+			task.parentID = parent.id
+			task.list = parent.list
+			parent.subtasks = append(parent.subtasks, task)
+			it = &parent.subtasks[len(parent.subtasks)-1]
+			result.description = fmt.Sprintf("Subtask has been added to %s", parent.name)
+		}
+		return
+	}
+
+	owner.tasks = append(owner.tasks, task)
+	it = &owner.tasks[len(owner.tasks)-1]
+	if owner != u {
+		s.assignee = owner
+		it.assignedBy = u.id
+		result.description = fmt.Sprintf("Task has been assigned to <@%s>", owner.id)
+	}
+	return
+}
+
+func (s *staffMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	owner := u
+	if s.assignee != nil {
+		owner = s.assignee
+	}
+	if it == nil {
+		return nil
+	}
+	if s.placeholder {
+		if err := ctx.app.registerUser(owner); err != nil {
+			log.Println("DB access failure: ", err)
+			return saveFailed(s, "Task")
+		}
+	}
+	if !ctx.app.createItem(u, owner, it) {
+		return saveFailed(s, "Task")
+	}
+	if it.assignedBy != "" {
+		ctx.app.notifyAssignment(owner, it)
+	}
+	if it.parentID != 0 {
+		ctx.app.syncParent(owner, it.parentID)
+	}
+	return nil
+}
+
+func (r *reassignMeCommand) String() string { return "Reassign me!" }
+func (r *reassignMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: r.String(),
+	}
+
+	assignee, registered, err := resolveAssignee(ctx, r.targets)
+	if err != nil {
+		result.status = statusError
+		result.description = err.Error()
+		return
+	}
+	owner, index := findAssignableTask(ctx.app.users, u, r.identifier)
+	if index == -1 {
+		result.status = statusError
+		result.description = fmt.Sprintf("task %s does not exist", r.identifier)
+		return
+	}
+
+	creator := owner.tasks[index].assignedBy
+	if creator == "" {
+		creator = owner.id
+	}
+	r.before = snapshotItem(owner, &owner.tasks[index])
+	it = moveTask(owner, assignee, owner.tasks[index].id)
+	it.assignedBy = creator
+	it.accepted = false
+	if assignee.id == creator {
+		// Back to the user who created it
+		it.assignedBy = ""
+	}
+	r.assignee, r.placeholder = assignee, !registered
+
+	result.description = fmt.Sprintf("Task has been assigned to <@%s>", assignee.id)
+	return
+}
+
+func (r *reassignMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if it == nil {
+		return nil
+	}
+	if r.placeholder {
+		if err := ctx.app.registerUser(r.assignee); err != nil {
+			log.Println("DB access failure: ", err)
+			// Back to the user it was taken from
+			task := moveTask(r.assignee, ctx.app.users[r.before.Owner], it.id)
+			task.assignedBy, task.accepted = r.before.AssignedBy, r.before.Accepted
+			return &commandResult{
+				title:       r.String(),
+				description: fmt.Sprintf("%s could not be reassigned", task.name),
+				status:      statusError,
+			}
+		}
+	}
+	ctx.app.saveAssignment(r.assignee, it)
+	ctx.app.recordChange(u.id, changeEdited, r.before, snapshotItem(r.assignee, it))
+	return nil
+}
+
+// Tasks are assigned to a single user. The assignee is not registered
+// yet when they never wrote to the bot, the commit of the command does
+func resolveAssignee(ctx *commandContext, targets []mention) (assignee *user, registered bool, err error) {
+	if len(targets) != 1 || targets[0].kind != mentionUser {
+		return nil, false, fmt.Errorf("A task can only be assigned to one user")
+	}
+	// Named after their ID until they write to the bot
+	return ctx.app.findUser(&discordgo.User{ID: targets[0].id, Username: targets[0].id})
+}
+
+func (b *briefTeamCommand) String() string { return "Brief team!" }
+func (b *briefTeamCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: b.String(),
+	}
+
+	now := ctx.clock.now()
+	for _, member := range sortedUsers(ctx.app.users) {
+		open := make([]item, 0, len(member.tasks))
+		for _, task := range member.tasks {
+			if !task.done {
+				open = append(open, task)
+			}
+		}
+		sortItems(open, member.sortOrder, now)
+		if len(open) > 0 {
+			result.addSection(member.name, iconTasks, briefItems(open, now), "")
+		}
+	}
+	if len(result.sections) == 0 {
+		result.description = "No open tasks"
 	}
 	return
 }
 
-func (r *removeMeCommand) getKind() commandKind { return r.kind }
-func (r *removeMeCommand) String() string       { return "Staff me!" }
-func (r *removeMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed, it *item) {
+func (r *removeMeCommand) String() string { return "Remove me!" }
+func (r *removeMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
 	found := false
 	removed := &item{}
 	switch r.list.kind {
 	case tokenReminder:
-		for i, item := range u.reminders {
-			if item.name == r.identifier {
-				found = true
-				*removed = item
-				if len(u.reminders) > 1 {
-					copy(u.reminders[i-1:], u.reminders[i:])
-					u.reminders = u.reminders[:len(u.reminders)-1]
-				} else {
-					u.reminders = u.reminders[:0]
-				}
-				break
-			}
+		if index := findItemByName(u.reminders, r.identifier); index != -1 {
+			found = true
+			*removed = u.reminders[index]
+			u.reminders = removeItemByID(u.reminders, removed.id)
 		}
 	case tokenTask:
-		for i, item := range u.tasks {
-			if item.name == r.identifier {
-				found = true
-				*removed = item
-				if len(u.tasks) > 1 {
-					copy(u.tasks[i-1:], u.tasks[i:])
-					u.tasks = u.tasks[:len(u.tasks)-1]
-				} else {
-					u.tasks = u.tasks[:0]
+		if r.parent != "" {
+			if parent := findItemByName(u.tasks, r.parent); parent != -1 {
+				subtasks := &u.tasks[parent].subtasks
+				if index := findItemByName(*subtasks, r.identifier); index != -1 {
+					found = true
+					*removed = (*subtasks)[index]
+					*subtasks = removeItemByID(*subtasks, removed.id)
 				}
-				break
 			}
+		} else if index := findItemByName(u.tasks, r.identifier); index != -1 {
+			found = true
+			*removed = u.tasks[index]
+			u.tasks = removeItemByID(u.tasks, removed.id)
 		}
 	}
 
@@ -223,22 +621,262 @@ func (r *removeMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed
 		listName = "task"
 	}
 
-	confirmation = &discordgo.MessageEmbed{
-		Type:  discordgo.EmbedTypeRich,
-		Title: r.String(),
+	result = &commandResult{
+		title: r.String(),
 	}
 	if found {
-		confirmation.Description = fmt.Sprintf("%s has been removed", listName)
+		result.description = fmt.Sprintf("%s has been removed", listName)
 		it = removed
 	} else {
-		confirmation.Description = fmt.Sprintf("%s %s does not exist", listName, r.identifier)
+		result.status = statusError
+		result.description = fmt.Sprintf("%s %s does not exist", listName, r.identifier)
+	}
+	return
+}
+
+func (r *removeMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if it == nil {
+		return nil
+	}
+	if err := ctx.app.dbDeleteItem(it); err != nil {
+		log.Println("DB access failure: ", err)
+		u.putItem(*it)
+		return &commandResult{
+			title:       r.String(),
+			description: fmt.Sprintf("%s could not be removed", it.name),
+			status:      statusError,
+		}
+	}
+	if it.parentID != 0 {
+		ctx.app.syncParent(u, it.parentID)
+	}
+	ctx.app.recordChange(u.id, changeDeleted, snapshotItem(u, it), nil)
+	return nil
+}
+
+func (d *doneMeCommand) String() string { return "Done me!" }
+func (d *doneMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: d.String(),
+	}
+
+	var parent *item
+	tasks := u.tasks
+	if d.parent != "" {
+		index := findItemByName(u.tasks, d.parent)
+		if index == -1 {
+			result.status = statusError
+			result.description = fmt.Sprintf("task %s does not exist", d.parent)
+			return
+		}
+		parent = &u.tasks[index]
+		tasks = parent.subtasks
+	}
+	index := findItemByName(tasks, d.identifier)
+	if index == -1 {
+		result.status = statusError
+		result.description = fmt.Sprintf("task %s does not exist", d.identifier)
+		return
+	}
+	if tasks[index].done {
+		result.status = statusError
+		result.description = fmt.Sprintf("task %s is already done", d.identifier)
+		return
+	}
+
+	it = &tasks[index]
+	d.before = snapshotItem(u, it)
+	it.done = true
+	result.description = "Task has been done"
+	if parent != nil {
+		done, total := subtaskProgress(parent)
+		result.description = fmt.Sprintf("Subtask has been done, %s is at %d/%d", parent.name, done, total)
+	}
+	return
+}
+
+func (d *doneMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if it != nil {
+		ctx.app.saveTaskDone(u, it)
+		ctx.app.recordChange(u.id, changeCompleted, d.before, snapshotItem(u, it))
+	}
+	return nil
+}
+
+func (s *sortMeCommand) String() string { return "Sort me!" }
+func (s *sortMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	u.sortOrder = s.order
+	result = &commandResult{
+		title:       s.String(),
+		description: fmt.Sprintf("Your briefings are now sorted by %s", sortOrderString[s.order]),
+	}
+	return
+}
+
+func (s *sortMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	err := ctx.app.db.Exec("UPDATE users SET sort_order = ? WHERE id = ?;", sortOrderString[s.order], u.uniqueID)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	return nil
+}
+
+func (d *digestMeCommand) String() string { return "Digest me!" }
+func (d *digestMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: d.String(),
+	}
+	if d.off {
+		u.digest = nil
+		result.description = "Your digest is turned off"
+		return
+	}
+
+	// A time of the day already passed today waits for the next day
+	schedule := d.schedule
+	schedule.lastSent = ctx.clock.now()
+	u.digest = &schedule
+	result.description = fmt.Sprintf("Your digest is sent %s", schedule.String())
+	return
+}
+
+func (d *digestMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	ctx.app.saveDigest(u)
+	return nil
+}
+
+func (q *quietMeCommand) String() string { return "Quiet me!" }
+func (q *quietMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	if q.off {
+		u.quiet = nil
+	} else {
+		hours := q.hours
+		u.quiet = &hours
+	}
+	if q.timeZone != nil {
+		u.timeZone = q.timeZone
+	}
+	result = &commandResult{
+		title:       q.String(),
+		description: quietDescription(u),
 	}
 	return
 }
 
-func (h *helpMeCommand) getKind() commandKind { return h.kind }
-func (h *helpMeCommand) String() string       { return "Help me!" }
-func (h *helpMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed, it *item) {
+func (q *quietMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	ctx.app.saveQuietSettings(u)
+	return nil
+}
+
+func (d *dndCommand) String() string { return "Do not disturb" }
+func (d *dndCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: d.String(),
+	}
+	if d.off {
+		u.dndUntil = time.Time{}
+		result.description = "Do not disturb is off, held alarms are on their way"
+		return
+	}
+	u.dndUntil = ctx.clock.now().Add(d.duration)
+	result.description = fmt.Sprintf(
+		"Alarms are held until %s, urgent (p1) ones excepted",
+		u.dndUntil.In(u.location()).Format("15:04 MST"),
+	)
+	return
+}
+
+func (d *dndCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	ctx.app.saveQuietSettings(u)
+	return nil
+}
+
+func (c *configureCommand) String() string { return "Configure" }
+func (c *configureCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	fail := func(format string, args ...interface{}) *commandResult {
+		return &commandResult{
+			title:       c.String(),
+			description: fmt.Sprintf(format, args...),
+			status:      statusError,
+		}
+	}
+	if ctx.guildID == "" {
+		result = fail("The settings only exist in a server")
+		return
+	}
+
+	g, exist := ctx.app.guilds[ctx.guildID]
+	if !exist {
+		g = newGuildSettings()
+	}
+	switch c.setting {
+	case configurePrefix:
+		g.prefix = c.value
+	case configureAlias:
+		if _, builtin := commands.lookup(c.value); builtin {
+			result = fail("%s is already a command", c.value)
+			return
+		}
+		if c.command == "" {
+			delete(g.aliases, c.value)
+			break
+		}
+		spec, exist := commands.lookup(c.command)
+		if !exist {
+			result = fail("%s is not a command", c.command)
+			return
+		}
+		g.aliases[c.value] = spec.name
+	case configureRole:
+		previous, granted := g.roles[c.value]
+		if len(c.capabilities) == 0 {
+			delete(g.roles, c.value)
+		} else {
+			g.roles[c.value] = c.capabilities
+		}
+		// Undone when it would lock the author out of the settings
+		if !g.allows(ctx.roles, capabilitySettings) {
+			if granted {
+				g.roles[c.value] = previous
+			} else {
+				delete(g.roles, c.value)
+			}
+			result = fail("You would not be allowed to change the settings anymore, grant `%s` to one of your roles first", capabilitySettings)
+			return
+		}
+	}
+	ctx.app.guilds[ctx.guildID] = g
+
+	result = guildDescription(g)
+	result.title = c.String()
+	return
+}
+
+func (c *configureCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	ctx.app.saveGuild(ctx.guildID)
+	return nil
+}
+
+func (h *helpMeCommand) String() string { return "Help me!" }
+func (h *helpMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	if h.topic != "" {
+		name := h.topic
+		if command, exist := ctx.app.guild(ctx.guildID).aliases[name]; exist {
+			name = command
+		}
+		spec, exist := commands.lookup(name)
+		if !exist {
+			result = &commandResult{
+				title:       h.String(),
+				description: fmt.Sprintf("!%s is not a command, see `!helpme` for the list", h.topic),
+				status:      statusError,
+			}
+			return
+		}
+		result = spec.help(h.String())
+		return
+	}
+
 	b := strings.Builder{}
 
 	b.WriteString("**RemindMeBot is a scheduling and task management tool.**\n")
@@ -247,32 +885,137 @@ func (h *helpMeCommand) execute(u *user) (confirmation *discordgo.MessageEmbed,
 	b.WriteString("Dates follow one the following format: \n")
 	b.WriteString("`h:min`, `dd-mm-yy`, `dd-mm-yy h:min`\n\n") //`[day keywords]`, `[day keywords] h:min`
 	// b.WriteString("The valid daye keywords are:\n`today`, `tomorrow`, `monday` `tuesday`, `wednesday`, `thursday`, `friday`, `saturday`,`sunday`\n")
+	b.WriteString("Use `!helpme <command>` for the details and examples of a command.\n")
 
-	confirmation = &discordgo.MessageEmbed{
-		Type:        discordgo.EmbedTypeRich,
-		Title:       h.String(),
-		Description: strings.Clone(b.String()),
+	result = &commandResult{
+		title:       h.String(),
+		description: strings.Clone(b.String()),
 	}
+	for _, spec := range commands.specs {
+		result.addTextSection(fmt.Sprintf("`!%s`", spec.name), spec.summary())
+	}
+	return
+}
 
-	confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-		Name:  "`!briefme`",
-		Value: "No required arguments.\nDisplay all the active reminders and tasks of the user",
-	})
-	confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-		Name:  "`!remindme`",
-		Value: "`name of the reminder`, `date`.\nAdd a reminder for the user",
-	})
-	confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-		Name:  "`!staffme`",
-		Value: "`name of the task`, (optional)`date`.\nAdd a task for the user",
-	})
-	confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-		Name:  "`!removeme`",
-		Value: "`type of the item`, `name of the task`.\nRemove either a task or a reminder for the user",
-	})
-	confirmation.Fields = append(confirmation.Fields, &discordgo.MessageEmbedField{
-		Name:  "`!helpme`",
-		Value: "No required arguments.\nDisplay the commands and how to use the bot",
-	})
+func (i *importMeCommand) String() string { return "Import me!" }
+func (i *importMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	for _, file := range i.files {
+		if file.err != nil {
+			i.skipped = append(i.skipped, fmt.Sprintf("%s: %s", file.name, file.err))
+			continue
+		}
+		entries, err := parseImportFile(file)
+		if err != nil {
+			i.skipped = append(i.skipped, fmt.Sprintf("%s: %s", file.name, err))
+			continue
+		}
+
+		for _, entry := range entries {
+			cmd, err := entry.command()
+			if err != nil {
+				i.skipped = append(i.skipped, fmt.Sprintf("%s: %s", entry.Name, err))
+				continue
+			}
+
+			var list []item
+			var name string
+			switch c := cmd.(type) {
+			case *remindMeCommand:
+				list, name = u.reminders, c.identifier
+			case *staffMeCommand:
+				list, name = u.tasks, c.identifier
+			}
+			if findItemByName(list, name) != -1 {
+				i.duplicated = append(i.duplicated, name)
+				continue
+			}
+
+			_, added := cmd.execute(ctx, u)
+			i.imported = append(i.imported, *added)
+		}
+	}
+
+	result = &commandResult{
+		title:       i.String(),
+		description: fmt.Sprintf("%d item(s) have been imported", len(i.imported)),
+	}
+	if len(i.files) == 0 {
+		result.status = statusError
+		result.description = "No file attached to the message"
+		return
+	}
+	i.addReportSection(result, "Imported", importedNames(i.imported))
+	i.addReportSection(result, "Skipped", i.skipped)
+	i.addReportSection(result, "Duplicated", i.duplicated)
+	return
+}
+
+// Nothing is imported when the items cannot all be stored
+func (i *importMeCommand) commit(ctx *commandContext, u *user, it *item) *commandResult {
+	if len(i.imported) == 0 {
+		return nil
+	}
+	if err := ctx.app.insertItems(u, i.imported); err != nil {
+		log.Println("DB access failure: ", err)
+		for _, imported := range i.imported {
+			u.reminders = removeItemByID(u.reminders, imported.id)
+			u.tasks = removeItemByID(u.tasks, imported.id)
+		}
+		return &commandResult{
+			title:       i.String(),
+			description: "Import failed, nothing has been imported",
+			status:      statusError,
+		}
+	}
+	for k := range i.imported {
+		ctx.app.emitEvent(eventItemCreated, u, &i.imported[k])
+		ctx.app.recordChange(u.id, changeCreated, nil, snapshotItem(u, &i.imported[k]))
+	}
+	return nil
+}
+
+func (i *importMeCommand) addReportSection(r *commandResult, name string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	r.addSection(fmt.Sprintf("%s (%d)", name, len(lines)), iconNone, bulletItems(lines), "")
+}
+
+func (c *undoMeCommand) String() string { return "Undo me!" }
+func (c *undoMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: c.String(),
+	}
+	undone, err := ctx.app.undoLastChange(u)
+	if err != nil {
+		result.status = statusError
+		result.description = err.Error()
+		return
+	}
+	result.description = fmt.Sprintf("**%s** %s on %s has been undone", undone.name, undone.change, undone.at)
+	return
+}
+
+func (h *historyMeCommand) String() string { return "History me!" }
+func (h *historyMeCommand) execute(ctx *commandContext, u *user) (result *commandResult, it *item) {
+	result = &commandResult{
+		title: h.String(),
+	}
+	events, err := ctx.app.itemHistory(u, h.parent, h.identifier)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	if len(events) == 0 {
+		result.status = statusError
+		result.description = fmt.Sprintf("no history for %s", h.identifier)
+		return
+	}
+
+	lines := make([]string, len(events))
+	for i := range events {
+		lines[i] = events[i].String()
+	}
+	result.description = fmt.Sprintf("**%s**", events[len(events)-1].name)
+	result.addSection("Timeline", iconNone, bulletItems(lines), "")
 	return
 }
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"remindMeBot/toml"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigPath  = "./data/config.toml"
+	configPollInterval = 2 * time.Second
+	redacted           = "<redacted>"
+
+	envConfigPath = "REMINDME_CONFIG"
+	envToken      = "REMINDME_TOKEN"
+	envTokenFile  = "REMINDME_TOKEN_FILE"
+	envDatabase   = "REMINDME_DATABASE"
+	envChannel    = "REMINDME_CHANNEL"
+	envAPIAddress = "REMINDME_API_ADDRESS"
+)
+
+type (
+	// Everything needed to start the bot, merged from
+	// config.toml, the environment and the command line,
+	// in that order of precedence
+	launchConfig struct {
+		configPath  string
+		token       string
+		tokenSource string
+		app         appConfig
+	}
+
+	configFlags struct {
+		set         map[string]bool
+		configPath  string
+		token       string
+		tokenFile   string
+		database    string
+		channel     string
+		apiAddress  string
+		checkConfig bool
+	}
+
+	configErrors []string
+)
+
+func (errs configErrors) Error() string {
+	b := strings.Builder{}
+	b.WriteString("invalid configuration:")
+	for _, err := range errs {
+		b.WriteString("\n  - ")
+		b.WriteString(err)
+	}
+	return b.String()
+}
+
+func defaultAppConfig() appConfig {
+	config := appConfig{
+		ReminderFrequency: 30,
+		Database:          "./data/remindme",
+		RemindChannel:     "649758541376127015",
+	}
+	config.AlarmTime.First = 120
+	config.AlarmTime.Second = 30
+	config.API.Address = "127.0.0.1:8080"
+	config.Outbox.ChannelRate = defaultChannelRate
+	return config
+}
+
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	flags := &configFlags{}
+	fs.StringVar(&flags.configPath, "config", "", "path of the config file (env "+envConfigPath+")")
+	fs.StringVar(&flags.token, "key", "", "the remindMeBot token string (env "+envToken+")")
+	fs.StringVar(&flags.tokenFile, "token-file", "", "path of a file holding the bot token (env "+envTokenFile+")")
+	fs.StringVar(&flags.database, "db", "", "path of the database (env "+envDatabase+")")
+	fs.StringVar(&flags.channel, "channel", "", "ID of the channel the reminders are sent to (env "+envChannel+")")
+	fs.StringVar(&flags.apiAddress, "api-address", "", "address of the HTTP API (env "+envAPIAddress+")")
+	fs.BoolVar(&flags.checkConfig, "check-config", false, "print the effective configuration and exit")
+	return flags
+}
+
+// Must be called after the flag set has been parsed
+func (f *configFlags) collect(fs *flag.FlagSet) {
+	f.set = make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		f.set[fl.Name] = true
+	})
+}
+
+func loadConfig(flags *configFlags, requireToken bool) (launchConfig, error) {
+	path := defaultConfigPath
+	if envPath, exist := os.LookupEnv(envConfigPath); exist {
+		path = envPath
+	}
+	if flags.set["config"] {
+		path = flags.configPath
+	}
+	return loadConfigFrom(path, flags, requireToken)
+}
+
+func loadConfigFrom(path string, flags *configFlags, requireToken bool) (result launchConfig, err error) {
+	var errs configErrors
+	result.app = defaultAppConfig()
+	result.configPath = path
+
+	configFile, ferr := os.ReadFile(result.configPath)
+	switch {
+	case os.IsNotExist(ferr) && !flags.set["config"]:
+		// Running on defaults only
+	case ferr != nil:
+		errs = append(errs, fmt.Sprintf("cannot read %s: %v", result.configPath, ferr))
+	default:
+		if derr := toml.Deserialize(string(configFile), &result.app); derr != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", result.configPath, derr))
+		}
+	}
+
+	overrides := []struct {
+		env   string
+		flag  string
+		value string
+		field *string
+	}{
+		{envDatabase, "db", flags.database, &result.app.Database},
+		{envChannel, "channel", flags.channel, &result.app.RemindChannel},
+		{envAPIAddress, "api-address", flags.apiAddress, &result.app.API.Address},
+	}
+	for _, o := range overrides {
+		if value, exist := os.LookupEnv(o.env); exist {
+			*o.field = value
+		}
+		if flags.set[o.flag] {
+			*o.field = o.value
+		}
+	}
+
+	switch {
+	case flags.set["key"]:
+		result.token, result.tokenSource = flags.token, "-key"
+	case flags.set["token-file"]:
+		result.token, result.tokenSource, err = readTokenFile(flags.tokenFile)
+	case os.Getenv(envToken) != "":
+		result.token, result.tokenSource = os.Getenv(envToken), envToken
+	case os.Getenv(envTokenFile) != "":
+		result.token, result.tokenSource, err = readTokenFile(os.Getenv(envTokenFile))
+	}
+	if err != nil {
+		errs = append(errs, err.Error())
+		err = nil
+	} else if requireToken && result.token == "" {
+		errs = append(errs, fmt.Sprintf("no bot token, use -key, -token-file, %s or %s", envToken, envTokenFile))
+	}
+
+	errs = append(errs, result.app.validate()...)
+	if len(errs) > 0 {
+		err = errs
+	}
+	return
+}
+
+func readTokenFile(path string) (token, source string, err error) {
+	source = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("cannot read the token file: %v", err)
+		return
+	}
+	token = strings.TrimSpace(string(data))
+	if token == "" {
+		err = fmt.Errorf("token file %s is empty", path)
+	}
+	return
+}
+
+func (c *appConfig) validate() (errs []string) {
+	if c.ReminderFrequency <= 0 {
+		errs = append(errs, fmt.Sprintf("ReminderFrequency must be a number of minutes greater than 0, got %d", c.ReminderFrequency))
+	}
+	if c.Database == "" {
+		errs = append(errs, "Database must not be empty")
+	}
+	if !isSnowflake(c.RemindChannel) {
+		errs = append(errs, fmt.Sprintf("RemindChannel must be a Discord channel ID, got %q", c.RemindChannel))
+	}
+	if c.AlarmTime.Second <= 0 {
+		errs = append(errs, fmt.Sprintf("AlarmTime.Second must be a number of minutes greater than 0, got %d", c.AlarmTime.Second))
+	}
+	if c.AlarmTime.First <= c.AlarmTime.Second {
+		errs = append(errs, fmt.Sprintf(
+			"AlarmTime.First must be greater than AlarmTime.Second, got %d and %d",
+			c.AlarmTime.First,
+			c.AlarmTime.Second,
+		))
+	}
+	if c.API.Enabled {
+		if _, _, err := net.SplitHostPort(c.API.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("API.Address must be a host:port address, got %q", c.API.Address))
+		}
+		if c.API.Token == "" {
+			errs = append(errs, "API.Token must be set when the API is enabled")
+		}
+	}
+	if c.Outbox.ChannelRate < 0 {
+		errs = append(errs, fmt.Sprintf("Outbox.ChannelRate must not be negative, got %d", c.Outbox.ChannelRate))
+	}
+	for name, webhook := range c.Webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("Webhooks.%s.URL must be an http or https URL, got %q", name, webhook.URL))
+		}
+		for _, event := range strings.Split(webhook.Events, ",") {
+			event = strings.TrimSpace(event)
+			if event != "" && !webhookEvents[event] {
+				errs = append(errs, fmt.Sprintf("Webhooks.%s.Events has an unknown event %q", name, event))
+			}
+		}
+	}
+	return
+}
+
+func isSnowflake(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i += 1 {
+		if !isNumber(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Secrets are redacted
+func (l launchConfig) String() string {
+	config := l.app
+	if config.API.Token != "" {
+		config.API.Token = redacted
+	}
+	config.Webhooks = make(map[string]webhookConfig, len(l.app.Webhooks))
+	for name, webhook := range l.app.Webhooks {
+		if webhook.Secret != "" {
+			webhook.Secret = redacted
+		}
+		config.Webhooks[name] = webhook
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "# config file: %s\n", l.configPath)
+	if l.token != "" {
+		fmt.Fprintf(&b, "# token: %s (from %s)\n", redacted, l.tokenSource)
+	} else {
+		b.WriteString("# token: not set\n")
+	}
+	configStr, _ := toml.Serialize(&config)
+	b.WriteString(configStr)
+	return b.String()
+}
+
+func (a *app) watchConfig(stop chan bool) {
+	if info, err := os.Stat(a.configPath); err == nil {
+		a.configModTime = info.ModTime()
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(configPollInterval):
+			info, err := os.Stat(a.configPath)
+			if err != nil || info.ModTime().Equal(a.configModTime) {
+				continue
+			}
+			a.configModTime = info.ModTime()
+			a.reloadConfig()
+		}
+	}
+}
+
+// The config is swapped as a whole, or not at all
+// when the new one cannot be loaded
+func (a *app) reloadConfig() {
+	flags := a.configFlags
+	if flags == nil {
+		flags = &configFlags{}
+	}
+	loaded, err := loadConfigFrom(a.configPath, flags, false)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current config: %v", err)
+		return
+	}
+	next := loaded.app
+
+	a.do(func() { a.swapConfig(next) })
+}
+
+// Must run on the state goroutine
+func (a *app) swapConfig(next appConfig) {
+	if next.Database != a.config.Database {
+		log.Println("Config reload: Database cannot change without a restart")
+		next.Database = a.config.Database
+	}
+	if next.RemindChannel != a.config.RemindChannel {
+		log.Println("Config reload: RemindChannel cannot change without a restart")
+		next.RemindChannel = a.config.RemindChannel
+	}
+	if next.API != a.config.API {
+		log.Println("Config reload: API cannot change without a restart")
+		next.API = a.config.API
+	}
+
+	changes := diffConfig(a.config, next)
+	if len(changes) == 0 {
+		return
+	}
+	a.config = next
+	for _, change := range changes {
+		log.Println("Config reload:", change)
+	}
+}
+
+func diffConfig(old, next appConfig) (changes []string) {
+	diffConfigValue("", reflect.ValueOf(old), reflect.ValueOf(next), &changes)
+	return
+}
+
+func diffConfigValue(path string, old, next reflect.Value, changes *[]string) {
+	switch old.Kind() {
+	case reflect.Struct:
+		for i := 0; i < old.NumField(); i += 1 {
+			name := old.Type().Field(i).Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diffConfigValue(name, old.Field(i), next.Field(i), changes)
+		}
+
+	case reflect.Map:
+		keys := make(map[string]bool)
+		for _, key := range old.MapKeys() {
+			keys[key.String()] = true
+		}
+		for _, key := range next.MapKeys() {
+			keys[key.String()] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			name := path + "." + key
+			oldValue := old.MapIndex(reflect.ValueOf(key))
+			nextValue := next.MapIndex(reflect.ValueOf(key))
+			switch {
+			case !oldValue.IsValid():
+				*changes = append(*changes, fmt.Sprintf("%s added", name))
+			case !nextValue.IsValid():
+				*changes = append(*changes, fmt.Sprintf("%s removed", name))
+			default:
+				diffConfigValue(name, oldValue, nextValue, changes)
+			}
+		}
+
+	default:
+		if old.Interface() == next.Interface() {
+			return
+		}
+		if strings.HasSuffix(path, ".Secret") || strings.HasSuffix(path, ".Token") {
+			*changes = append(*changes, fmt.Sprintf("%s changed", path))
+		} else {
+			*changes = append(*changes, fmt.Sprintf("%s: %v -> %v", path, old.Interface(), next.Interface()))
+		}
+	}
+}
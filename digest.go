@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const everyDay = 1<<7 - 1
+
+type (
+	// Opt-in summary of the upcoming items, sent at a time of day
+	digestSchedule struct {
+		hour int
+		min  int
+		// One bit per time.Weekday
+		days int
+		// Empty for a direct message
+		channelID string
+		lastSent  time.Time
+	}
+)
+
+var weekdayTokens = map[tokenKind]time.Weekday{
+	tokenMonday:    time.Monday,
+	tokenTuesday:   time.Tuesday,
+	tokenWednesday: time.Wednesday,
+	tokenThursday:  time.Thursday,
+	tokenFriday:    time.Friday,
+	tokenSaturday:  time.Saturday,
+	tokenSunday:    time.Sunday,
+}
+
+// The digest is due once its time of the day has passed,
+// if it was not sent since
+func (d *digestSchedule) isDue(now time.Time) bool {
+	if d.days&(1<<now.Weekday()) == 0 {
+		return false
+	}
+	y, m, day := now.Date()
+	scheduled := time.Date(y, m, day, d.hour, d.min, 0, 0, now.Location())
+	return !now.Before(scheduled) && d.lastSent.Before(scheduled)
+}
+
+func (d *digestSchedule) String() string {
+	b := strings.Builder{}
+	if d.days == everyDay {
+		b.WriteString("every day")
+	} else {
+		var days []string
+		for day := time.Sunday; day <= time.Saturday; day += 1 {
+			if d.days&(1<<day) != 0 {
+				days = append(days, strings.ToLower(day.String()))
+			}
+		}
+		b.WriteString("on ")
+		b.WriteString(strings.Join(days, ", "))
+	}
+	fmt.Fprintf(&b, " at %02d:%02d", d.hour, d.min)
+	if d.channelID == "" {
+		b.WriteString(" by direct message")
+	} else {
+		fmt.Fprintf(&b, " in <#%s>", d.channelID)
+	}
+	return b.String()
+}
+
+func digestTitle(d *digestSchedule) string {
+	switch {
+	case d == nil || d.days == everyDay:
+		return "Daily digest"
+	case d.days&(d.days-1) == 0:
+		return "Weekly digest"
+	}
+	return "Digest"
+}
+
+// Today's reminders, the tasks due within a week and the overdue tasks,
+// the days being the ones of the user
+func digestResult(u *user, now time.Time) *commandResult {
+	now = now.In(u.location())
+	result := &commandResult{
+		title:       digestTitle(u.digest),
+		description: now.Format("Monday 2 January"),
+	}
+
+	y, m, d := now.Date()
+	var today, upcoming, overdue []item
+	for _, reminder := range u.reminders {
+		ry, rm, rd := reminder.dueTime.In(u.location()).Date()
+		if ry == y && rm == m && rd == d {
+			today = append(today, reminder)
+		}
+	}
+	weekEnd := now.AddDate(0, 0, 7)
+	for _, task := range u.tasks {
+		switch {
+		case task.isOverdue(now):
+			overdue = append(overdue, task)
+		case task.hasDueDate && !task.done && task.dueTime.Before(weekEnd):
+			upcoming = append(upcoming, task)
+		}
+	}
+	for _, items := range [][]item{today, upcoming, overdue} {
+		sortItems(items, u.sortOrder, now)
+	}
+
+	result.addSection("Today's reminders", iconReminders, briefItems(today, now), "No reminders today")
+	result.addSection("Due this week", iconTasks, briefItems(upcoming, now), "No tasks due this week")
+	result.addSection("Overdue tasks", iconTasks, briefItems(overdue, now), "No overdue tasks")
+	return result
+}
+
+func (a *app) initDigests() error {
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS digests (
+		user_id INTEGER PRIMARY KEY,
+		hour INTEGER NOT NULL,
+		minute INTEGER NOT NULL,
+		days INTEGER NOT NULL,
+		channel_id TEXT NOT NULL,
+		last_sent TEXT NOT NULL
+	);`)
+}
+
+// Must be called once the users are loaded
+func (a *app) loadDigests() error {
+	byUser := make(map[int]*digestSchedule)
+	results, err := a.db.Query("SELECT user_id, hour, minute, days, channel_id, last_sent FROM digests;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	err = results.Iterate(func(d types.Document) error {
+		var userID int
+		var lastSent string
+		digest := &digestSchedule{}
+
+		err := document.Scan(d, &userID, &digest.hour, &digest.min, &digest.days, &digest.channelID, &lastSent)
+		if err != nil {
+			return err
+		}
+		if lastSent != "" {
+			digest.lastSent, err = time.Parse(timeFormat, lastSent)
+		}
+		byUser[userID] = digest
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range a.users {
+		u.digest = byUser[u.uniqueID]
+	}
+	return nil
+}
+
+// Must run on the state goroutine
+func (a *app) saveDigest(u *user) {
+	err := a.db.Update(func(tx *genji.Tx) error {
+		err := tx.Exec("DELETE FROM digests WHERE user_id = ?;", u.uniqueID)
+		if err != nil || u.digest == nil {
+			return err
+		}
+		return tx.Exec(
+			"INSERT INTO digests (user_id, hour, minute, days, channel_id, last_sent) VALUES (?, ?, ?, ?, ?, ?);",
+			u.uniqueID,
+			u.digest.hour,
+			u.digest.min,
+			u.digest.days,
+			u.digest.channelID,
+			u.digest.lastSent.Format(timeFormat),
+		)
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Sends the digest of the user when it is due.
+// Must run on the state goroutine
+func (a *app) updateDigest(u *user, now time.Time) {
+	if u.digest == nil || !u.digest.isDue(now) {
+		return
+	}
+
+	msg := directMessage(u.id, digestResult(u, now))
+	if u.digest.channelID != "" {
+		msg = resultMessage(u.digest.channelID, digestResult(u, now))
+	}
+	if err := a.enqueue(msg); err != nil {
+		return
+	}
+
+	u.digest.lastSent = now
+	err := a.db.Exec("UPDATE digests SET last_sent = ? WHERE user_id = ?;", now.Format(timeFormat), u.uniqueID)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
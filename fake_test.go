@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/genjidb/genji"
+)
+
+const (
+	fakeBotID          = "bot"
+	fakeRemindChannel  = "remind"
+	fakeCommandChannel = "commands"
+	fakeGuild          = "guild"
+)
+
+type (
+	// In-process stand-in for Discord.
+	// Records everything the app sends and injects the events
+	// the gateway would deliver
+	fakeDiscord struct {
+		mut      sync.Mutex
+		app      *app
+		messages []*fakeMessage
+		pages    pager
+		// Number of the next sends that fail
+		failures int
+		// Sends wait on the gate while it is set
+		gate chan bool
+	}
+
+	fakeMessage struct {
+		id        string
+		channelID string
+		content   string
+		result    *commandResult
+		page      *discordgo.MessageEmbed
+		reactions []string
+		// Reactions of the users removed by the bot
+		removed []string
+	}
+
+	fakeClock struct {
+		mut     sync.Mutex
+		current time.Time
+	}
+)
+
+func (f *fakeDiscord) botUserID() string {
+	return fakeBotID
+}
+
+func (f *fakeDiscord) sendText(channelID, content string) (string, error) {
+	if err := f.fail(); err != nil {
+		return "", err
+	}
+	return f.record(&fakeMessage{channelID: channelID, content: content}), nil
+}
+
+func (f *fakeDiscord) sendResult(channelID string, r *commandResult) (string, error) {
+	if err := f.fail(); err != nil {
+		return "", err
+	}
+	pages := renderEmbeds(r)
+	msg := &fakeMessage{channelID: channelID, result: r, page: pages[0]}
+	if len(pages) > 1 {
+		msg.reactions = []string{prevPageEmoji, nextPageEmoji}
+	}
+	id := f.record(msg)
+	if len(pages) > 1 {
+		f.pages.add(id, pages)
+	}
+	return id, nil
+}
+
+func (f *fakeDiscord) addReaction(channelID, messageID, emoji string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	msg := f.find(messageID)
+	if msg == nil {
+		return fmt.Errorf("unknown message %s", messageID)
+	}
+	msg.reactions = append(msg.reactions, emoji)
+	return nil
+}
+
+func (f *fakeDiscord) getMessage(channelID, messageID string) (*discordgo.Message, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	msg := f.find(messageID)
+	if msg == nil {
+		return nil, fmt.Errorf("unknown message %s", messageID)
+	}
+	result := &discordgo.Message{
+		ID:        msg.id,
+		ChannelID: msg.channelID,
+		Content:   msg.content,
+		Author:    &discordgo.User{ID: fakeBotID, Bot: true},
+	}
+	if msg.result != nil {
+		result.Embeds = []*discordgo.MessageEmbed{msg.page}
+	}
+	return result, nil
+}
+
+func (f *fakeDiscord) turnPage(channelID, messageID, userID string, forward bool) error {
+	page, known := f.pages.turn(messageID, forward)
+	if !known {
+		return nil
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	msg := f.find(messageID)
+	if msg == nil {
+		return fmt.Errorf("unknown message %s", messageID)
+	}
+	if page != nil {
+		msg.page = page
+	}
+	emoji := prevPageEmoji
+	if forward {
+		emoji = nextPageEmoji
+	}
+	msg.removed = append(msg.removed, emoji)
+	return nil
+}
+
+func (f *fakeDiscord) directChannel(userID string) (string, error) {
+	return "dm-" + userID, nil
+}
+
+func (f *fakeDiscord) failNext(n int) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.failures = n
+}
+
+// Holds every send until the returned function is called,
+// as a stalled Discord API would
+func (f *fakeDiscord) stall() (release func()) {
+	gate := make(chan bool)
+	f.mut.Lock()
+	f.gate = gate
+	f.mut.Unlock()
+
+	return func() {
+		f.mut.Lock()
+		f.gate = nil
+		f.mut.Unlock()
+		close(gate)
+	}
+}
+
+func (f *fakeDiscord) fail() error {
+	f.mut.Lock()
+	gate := f.gate
+	f.mut.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.failures == 0 {
+		return nil
+	}
+	f.failures -= 1
+	return fmt.Errorf("service unavailable")
+}
+
+func (f *fakeDiscord) record(msg *fakeMessage) string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	msg.id = strconv.Itoa(len(f.messages) + 1)
+	f.messages = append(f.messages, msg)
+	return msg.id
+}
+
+// The caller must hold f.mut
+func (f *fakeDiscord) find(messageID string) *fakeMessage {
+	for _, msg := range f.messages {
+		if msg.id == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+// Injected events and ticks deliver the outbox right away,
+// as the outbox worker would
+func (f *fakeDiscord) injectMessage(channelID, authorID, content string, roles ...string) {
+	f.post(channelID, authorID, content, roles...)
+	f.app.deliverOutbox()
+}
+
+// Handles the message, its reply stays in the outbox
+func (f *fakeDiscord) post(channelID, authorID, content string, roles ...string) {
+	f.app.handleMessage(&discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			GuildID:   fakeGuild,
+			ChannelID: channelID,
+			Content:   content,
+			Author:    &discordgo.User{ID: authorID, Username: authorID},
+			Member:    &discordgo.Member{Roles: roles},
+		},
+	})
+}
+
+func (f *fakeDiscord) injectReaction(channelID, messageID, userID, emoji string, roles ...string) {
+	f.app.handleReaction(&discordgo.MessageReactionAdd{
+		MessageReaction: &discordgo.MessageReaction{
+			UserID:    userID,
+			MessageID: messageID,
+			ChannelID: channelID,
+			GuildID:   fakeGuild,
+			Emoji:     discordgo.Emoji{Name: emoji},
+		},
+		Member: &discordgo.Member{Roles: roles},
+	})
+	f.app.deliverOutbox()
+}
+
+func (f *fakeDiscord) tick() {
+	f.app.tick()
+	f.app.deliverOutbox()
+}
+
+func (f *fakeDiscord) sent() []*fakeMessage {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return append([]*fakeMessage(nil), f.messages...)
+}
+
+func (f *fakeDiscord) last() *fakeMessage {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if len(f.messages) == 0 {
+		return nil
+	}
+	return f.messages[len(f.messages)-1]
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.current = c.current.Add(d)
+}
+
+// Creates an app backed by an in-memory database, the fake
+// transport and a clock stopped at the given time
+func newTestApp(t *testing.T, start time.Time) (*app, *fakeDiscord, *fakeClock) {
+	db, err := genji.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	discord := &fakeDiscord{}
+	clock := &fakeClock{current: start}
+	a := &app{
+		s:               discord,
+		remindChannelID: fakeRemindChannel,
+		db:              db,
+		shouldClose:     make(chan bool),
+		users:           make(map[string]*user),
+		clock:           clock,
+	}
+	discord.app = a
+	a.init()
+	a.config.ReminderFrequency = 30
+	a.config.AlarmTime.First = 120
+	a.config.AlarmTime.Second = 30
+	return a, discord, clock
+}
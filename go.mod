@@ -2,7 +2,10 @@ module remindMeBot
 
 go 1.18
 
-require github.com/bwmarrin/discordgo v0.25.0
+require (
+	github.com/bwmarrin/discordgo v0.25.0
+	github.com/genjidb/genji v0.14.1
+)
 
 require (
 	github.com/agnivade/levenshtein v1.1.1 // indirect
@@ -14,7 +17,6 @@ require (
 	github.com/dgraph-io/badger/v3 v3.2103.2 // indirect
 	github.com/dgraph-io/ristretto v0.1.0 // indirect
 	github.com/dustin/go-humanize v1.0.0 // indirect
-	github.com/genjidb/genji v0.14.1 // indirect
 	github.com/genjidb/genji/cmd/genji v0.14.2 // indirect
 	github.com/genjidb/genji/engine/badgerengine v0.14.1 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
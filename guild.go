@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const (
+	defaultPrefix   = "!"
+	maxPrefixLength = 32
+
+	configurePrefix = "prefix"
+	configureAlias  = "alias"
+	configureRole   = "role"
+)
+
+type (
+	// Settings of a Discord server, administered with !configure
+	guildSettings struct {
+		prefix string
+		// Alias to the name of the command it stands for
+		aliases map[string]string
+		// Role ID to the capabilities granted to its members
+		roles map[string][]capability
+	}
+)
+
+func newGuildSettings() *guildSettings {
+	return &guildSettings{
+		prefix:  defaultPrefix,
+		aliases: make(map[string]string),
+		roles:   make(map[string][]capability),
+	}
+}
+
+// Rewrites the message in the bang syntax of the parser, with the
+// aliases resolved and the command name in lowercase.
+// Returns false when the message is not meant for the bot.
+// A mention of the bot always works as a prefix
+func (g *guildSettings) commandText(content, botID string) (string, bool) {
+	content = strings.TrimSpace(content)
+	var rest string
+	switch {
+	case strings.HasPrefix(content, "<@"+botID+">"):
+		rest = strings.TrimPrefix(content, "<@"+botID+">")
+	case strings.HasPrefix(content, "<@!"+botID+">"):
+		rest = strings.TrimPrefix(content, "<@!"+botID+">")
+	case strings.HasPrefix(content, g.prefix):
+		rest = strings.TrimPrefix(content, g.prefix)
+	case g.prefix == defaultPrefix:
+		// Left to the parser, which tells what it expected
+		return content, true
+	default:
+		return "", false
+	}
+
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	end := strings.IndexFunc(rest, unicode.IsSpace)
+	if end == -1 {
+		end = len(rest)
+	}
+	name := strings.ToLower(rest[:end])
+	if command, exist := g.aliases[name]; exist {
+		name = command
+	}
+	return defaultPrefix + name + rest[end:], true
+}
+
+func (g *guildSettings) aliasNames() []string {
+	names := make([]string, 0, len(g.aliases))
+	for alias := range g.aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *guildSettings) roleIDs() []string {
+	ids := make([]string, 0, len(g.roles))
+	for id := range g.roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Settings of the server, the defaults outside of servers.
+// Must run on the state goroutine
+func (a *app) guild(guildID string) *guildSettings {
+	if g, exist := a.guilds[guildID]; exist {
+		return g
+	}
+	return newGuildSettings()
+}
+
+func (a *app) initGuilds() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS guild_settings (
+		guild_id TEXT PRIMARY KEY,
+		prefix TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec(`CREATE TABLE IF NOT EXISTS guild_aliases (
+		guild_id TEXT NOT NULL,
+		alias TEXT NOT NULL,
+		command TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS guild_roles (
+		guild_id TEXT NOT NULL,
+		role_id TEXT NOT NULL,
+		capability TEXT NOT NULL
+	);`)
+}
+
+func (a *app) loadGuilds() error {
+	a.guilds = make(map[string]*guildSettings)
+	results, err := a.db.Query("SELECT guild_id, prefix FROM guild_settings;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	err = results.Iterate(func(d types.Document) error {
+		var guildID string
+		g := newGuildSettings()
+		err := document.Scan(d, &guildID, &g.prefix)
+		a.guilds[guildID] = g
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	aliases, err := a.db.Query("SELECT guild_id, alias, command FROM guild_aliases;")
+	if err != nil {
+		return err
+	}
+	defer aliases.Close()
+
+	err = aliases.Iterate(func(d types.Document) error {
+		var guildID, alias, command string
+		err := document.Scan(d, &guildID, &alias, &command)
+		if g, exist := a.guilds[guildID]; exist {
+			g.aliases[alias] = command
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	roles, err := a.db.Query("SELECT guild_id, role_id, capability FROM guild_roles;")
+	if err != nil {
+		return err
+	}
+	defer roles.Close()
+
+	return roles.Iterate(func(d types.Document) error {
+		var guildID, roleID, granted string
+		err := document.Scan(d, &guildID, &roleID, &granted)
+		if g, exist := a.guilds[guildID]; exist {
+			g.roles[roleID] = append(g.roles[roleID], capability(granted))
+		}
+		return err
+	})
+}
+
+// Must run on the state goroutine
+func (a *app) saveGuild(guildID string) {
+	g, exist := a.guilds[guildID]
+	if !exist {
+		return
+	}
+	err := a.db.Update(func(tx *genji.Tx) error {
+		err := tx.Exec("DELETE FROM guild_settings WHERE guild_id = ?;", guildID)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM guild_aliases WHERE guild_id = ?;", guildID)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM guild_roles WHERE guild_id = ?;", guildID)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("INSERT INTO guild_settings (guild_id, prefix) VALUES (?, ?);", guildID, g.prefix)
+		if err != nil {
+			return err
+		}
+		for alias, command := range g.aliases {
+			err = tx.Exec("INSERT INTO guild_aliases (guild_id, alias, command) VALUES (?, ?, ?);", guildID, alias, command)
+			if err != nil {
+				return err
+			}
+		}
+		for roleID, capabilities := range g.roles {
+			for _, granted := range capabilities {
+				err = tx.Exec("INSERT INTO guild_roles (guild_id, role_id, capability) VALUES (?, ?, ?);", guildID, roleID, string(granted))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+func guildDescription(g *guildSettings) *commandResult {
+	result := &commandResult{
+		description: fmt.Sprintf("Commands start with `%s` or a mention of the bot", g.prefix),
+	}
+	var aliases []string
+	for _, alias := range g.aliasNames() {
+		aliases = append(aliases, fmt.Sprintf("%s%s stands for %s%s", g.prefix, alias, g.prefix, g.aliases[alias]))
+	}
+	result.addSection("Aliases", iconNone, bulletItems(aliases), "No aliases")
+
+	var roles []string
+	for _, roleID := range g.roleIDs() {
+		names := make([]string, len(g.roles[roleID]))
+		for i, granted := range g.roles[roleID] {
+			names[i] = string(granted)
+		}
+		roles = append(roles, fmt.Sprintf("<@&%s> can %s", roleID, strings.Join(names, ", ")))
+	}
+	result.addSection("Roles", iconNone, bulletItems(roles), "Every member can change the settings and assign tasks")
+	return result
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const (
+	changeCreated      = "created"
+	changeEdited       = "edited"
+	changeAlarmed      = "alarmed"
+	changeAcknowledged = "acknowledged"
+	changeCompleted    = "completed"
+	changeDeleted      = "deleted"
+	changeUndone       = "undone"
+)
+
+// Changes !undome reverts, alarms are not changes of the user
+var undoableChanges = map[string]bool{
+	changeCreated:      true,
+	changeEdited:       true,
+	changeAcknowledged: true,
+	changeCompleted:    true,
+	changeDeleted:      true,
+}
+
+type (
+	// State of an item as recorded in its history, and restored by !undome.
+	// Alarms and nags are left out, they start over with the restored item
+	itemSnapshot struct {
+		Owner      string              `json:"owner"`
+		ID         int                 `json:"id"`
+		Name       string              `json:"name"`
+		Kind       itemKind            `json:"kind"`
+		Due        string              `json:"due,omitempty"`
+		Done       bool                `json:"done,omitempty"`
+		Channel    string              `json:"channel,omitempty"`
+		Recipients []snapshotRecipient `json:"recipients,omitempty"`
+		Nag        *snapshotNag        `json:"nag,omitempty"`
+		AssignedBy string              `json:"assigned_by,omitempty"`
+		Accepted   bool                `json:"accepted,omitempty"`
+		List       string              `json:"list,omitempty"`
+		Tags       []string            `json:"tags,omitempty"`
+		Priority   priority            `json:"priority,omitempty"`
+		ParentID   int                 `json:"parent_id,omitempty"`
+		Subtasks   []itemSnapshot      `json:"subtasks,omitempty"`
+	}
+
+	snapshotRecipient struct {
+		Kind  mentionKind `json:"kind"`
+		ID    string      `json:"id"`
+		Acked bool        `json:"acked,omitempty"`
+	}
+
+	snapshotNag struct {
+		Intervals     []string `json:"intervals,omitempty"`
+		MaxNags       int      `json:"max_nags,omitempty"`
+		EscalateTo    string   `json:"escalate_to,omitempty"`
+		EscalateAfter int      `json:"escalate_after,omitempty"`
+	}
+
+	// Row of the append-only item_events table.
+	// Before is empty for a created item, after for a deleted one
+	itemEvent struct {
+		id     int
+		itemID int
+		// Discord ID of the owner of the item, and of the user who
+		// changed it, empty when the bot did
+		owner  string
+		actor  string
+		name   string
+		change string
+		at     string
+		before string
+		after  string
+		// Event reverted by an undone event
+		undoes int
+	}
+)
+
+func snapshotItem(u *user, it *item) *itemSnapshot {
+	s := &itemSnapshot{
+		Owner:      u.id,
+		ID:         it.id,
+		Name:       it.name,
+		Kind:       it.kind,
+		Channel:    it.channelID,
+		AssignedBy: it.assignedBy,
+		Accepted:   it.accepted,
+		List:       it.list,
+		Tags:       it.tags,
+		Priority:   it.priority,
+		ParentID:   it.parentID,
+	}
+	if it.hasDueDate {
+		s.Due = it.dueTime.Format(timeFormat)
+	}
+	// Reminders are marked done in memory once past due
+	if it.kind == itemTask {
+		s.Done = it.done
+	}
+	for _, r := range it.recipients {
+		s.Recipients = append(s.Recipients, snapshotRecipient{Kind: r.target.kind, ID: r.target.id, Acked: r.acked})
+	}
+	if it.nag != nil {
+		s.Nag = &snapshotNag{
+			MaxNags:       it.nag.maxNags,
+			EscalateTo:    it.nag.escalateTo,
+			EscalateAfter: it.nag.escalateAfter,
+		}
+		for _, interval := range it.nag.intervals {
+			s.Nag.Intervals = append(s.Nag.Intervals, interval.String())
+		}
+	}
+	for i := range it.subtasks {
+		s.Subtasks = append(s.Subtasks, *snapshotItem(u, &it.subtasks[i]))
+	}
+	return s
+}
+
+func (s *itemSnapshot) item() (item, error) {
+	it := item{
+		id:         s.ID,
+		name:       s.Name,
+		kind:       s.Kind,
+		done:       s.Done,
+		channelID:  s.Channel,
+		assignedBy: s.AssignedBy,
+		accepted:   s.Accepted,
+		list:       s.List,
+		tags:       s.Tags,
+		priority:   s.Priority,
+		parentID:   s.ParentID,
+	}
+	if s.Due != "" {
+		dueTime, err := time.Parse(timeFormat, s.Due)
+		if err != nil {
+			return it, err
+		}
+		it.hasDueDate, it.dueTime = true, dueTime
+	}
+	for _, r := range s.Recipients {
+		it.recipients = append(it.recipients, recipient{target: mention{kind: r.Kind, id: r.ID}, acked: r.Acked})
+	}
+	if s.Nag != nil {
+		intervals, err := parseIntervals(strings.Join(s.Nag.Intervals, " "))
+		if err != nil {
+			return it, err
+		}
+		it.nag = &nagPolicy{
+			intervals:     intervals,
+			maxNags:       s.Nag.MaxNags,
+			escalateTo:    s.Nag.EscalateTo,
+			escalateAfter: s.Nag.EscalateAfter,
+		}
+	}
+	for i := range s.Subtasks {
+		subtask, err := s.Subtasks[i].item()
+		if err != nil {
+			return it, err
+		}
+		it.subtasks = append(it.subtasks, subtask)
+	}
+	return it, nil
+}
+
+// Empty for a missing snapshot
+func encodeSnapshot(s *itemSnapshot) string {
+	if s == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		log.Println(err)
+	}
+	return string(encoded)
+}
+
+func decodeSnapshot(text string) (*itemSnapshot, error) {
+	if text == "" {
+		return nil, nil
+	}
+	s := &itemSnapshot{}
+	return s, json.Unmarshal([]byte(text), s)
+}
+
+func (a *app) initHistory() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS item_events (
+		id INTEGER PRIMARY KEY,
+		item_id INTEGER NOT NULL,
+		owner_id TEXT NOT NULL,
+		actor_id TEXT NOT NULL,
+		item_name TEXT NOT NULL,
+		change_kind TEXT NOT NULL,
+		changed_at TEXT NOT NULL,
+		before_value TEXT NOT NULL,
+		after_value TEXT NOT NULL,
+		undoes INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec("CREATE INDEX IF NOT EXISTS item_events_item_idx ON item_events (item_id);")
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec("CREATE INDEX IF NOT EXISTS item_events_actor_idx ON item_events (actor_id);")
+	if err != nil {
+		return err
+	}
+	return a.db.Exec("CREATE SEQUENCE IF NOT EXISTS item_event_seq;")
+}
+
+// Appends the change of the item to its history.
+// Must run on the state goroutine
+func (a *app) recordChange(actorID, change string, before, after *itemSnapshot) {
+	a.appendEvent(actorID, change, before, after, 0)
+}
+
+// Must run on the state goroutine
+func (a *app) appendEvent(actorID, change string, before, after *itemSnapshot, undoes int) {
+	s := after
+	if s == nil {
+		s = before
+	}
+	err := a.db.Exec(
+		"INSERT INTO item_events (id, item_id, owner_id, actor_id, item_name, change_kind, changed_at, before_value, after_value, undoes) VALUES (NEXT VALUE FOR item_event_seq, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		s.ID,
+		s.Owner,
+		actorID,
+		s.Name,
+		change,
+		a.clock.now().Format(timeFormat),
+		encodeSnapshot(before),
+		encodeSnapshot(after),
+		undoes,
+	)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Events in the order they were recorded, see deliverOutbox
+func (a *app) itemEvents(column string, value interface{}) ([]itemEvent, error) {
+	results, err := a.db.Query(
+		fmt.Sprintf("SELECT id, item_id, owner_id, actor_id, item_name, change_kind, changed_at, before_value, after_value, undoes FROM item_events WHERE %s = ?;", column),
+		value,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var events []itemEvent
+	err = results.Iterate(func(d types.Document) error {
+		var e itemEvent
+		err := document.Scan(d, &e.id, &e.itemID, &e.owner, &e.actor, &e.name, &e.change, &e.at, &e.before, &e.after, &e.undoes)
+		events = append(events, e)
+		return err
+	})
+	return events, err
+}
+
+// Last change of the user that was not undone yet
+func lastUndoable(events []itemEvent) (itemEvent, bool) {
+	undone := make(map[int]bool)
+	for _, e := range events {
+		if e.change == changeUndone {
+			undone[e.undoes] = true
+		}
+	}
+	for i := len(events) - 1; i >= 0; i -= 1 {
+		if undoableChanges[events[i].change] && !undone[events[i].id] {
+			return events[i], true
+		}
+	}
+	return itemEvent{}, false
+}
+
+// Puts the item back the way it was before the last change of the user.
+// Refused when the item changed since, so the change of someone else
+// is never lost.
+// Must run on the state goroutine
+func (a *app) undoLastChange(u *user) (itemEvent, error) {
+	events, err := a.itemEvents("actor_id", u.id)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return itemEvent{}, fmt.Errorf("the history could not be read")
+	}
+	e, found := lastUndoable(events)
+	if !found {
+		return e, fmt.Errorf("nothing to undo")
+	}
+
+	owner, current := a.itemOwner(e.itemID)
+	var currentSnapshot *itemSnapshot
+	if current != nil {
+		currentSnapshot = snapshotItem(owner, current)
+	}
+	if encodeSnapshot(currentSnapshot) != e.after {
+		return e, fmt.Errorf("**%s** changed since it was %s", e.name, e.change)
+	}
+	before, err := decodeSnapshot(e.before)
+	if err != nil {
+		return e, err
+	}
+
+	if current != nil {
+		a.deleteItem(owner, e.itemID)
+	}
+	if before != nil {
+		if err = a.restoreItem(before); err != nil {
+			log.Println("DB access failure: ", err)
+			return e, fmt.Errorf("**%s** could not be restored", e.name)
+		}
+	}
+	a.appendEvent(u.id, changeUndone, currentSnapshot, before, e.id)
+	return e, nil
+}
+
+// Adds the item back to its owner, a subtask whose task is gone
+// comes back as a task.
+// Must run on the state goroutine
+func (a *app) restoreItem(s *itemSnapshot) error {
+	owner, exist := a.users[s.Owner]
+	if !exist {
+		return fmt.Errorf("no user %s for item %d", s.Owner, s.ID)
+	}
+	it, err := s.item()
+	if err != nil {
+		return err
+	}
+	parent := -1
+	if it.parentID != 0 {
+		if parent = findItemByID(owner.tasks, it.parentID); parent == -1 {
+			it.parentID = 0
+		}
+	}
+	if it.hasDueDate {
+		it.alarmCount = a.sentAlarms(it.dueTime)
+	}
+
+	err = a.insertItems(owner, append([]item{it}, it.subtasks...))
+	if err != nil {
+		return err
+	}
+	owner.putItem(it)
+	if parent != -1 {
+		a.syncParent(owner, it.parentID)
+	}
+	return nil
+}
+
+// Timeline of the item of the user, found by its name among its items
+// or else among the items the user owned or changed
+func (a *app) itemHistory(u *user, parent, name string) ([]itemEvent, error) {
+	id := 0
+	if parent == "" {
+		if index := findItemByName(u.reminders, name); index != -1 {
+			id = u.reminders[index].id
+		} else if index := findItemByName(u.tasks, name); index != -1 {
+			id = u.tasks[index].id
+		}
+	} else if index := findItemByName(u.tasks, parent); index != -1 {
+		if sub := findItemByName(u.tasks[index].subtasks, name); sub != -1 {
+			id = u.tasks[index].subtasks[sub].id
+		}
+	}
+
+	if id == 0 {
+		// The latest item of that name the user owned or changed
+		latest := 0
+		for _, column := range []string{"owner_id", "actor_id"} {
+			events, err := a.itemEvents(column, u.id)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range events {
+				if e.name == name && e.id > latest {
+					id, latest = e.itemID, e.id
+				}
+			}
+		}
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	return a.itemEvents("item_id", id)
+}
+
+func (e *itemEvent) String() string {
+	line := fmt.Sprintf("%s %s", e.at, e.change)
+	if e.actor != "" {
+		line += fmt.Sprintf(" by <@%s>", e.actor)
+	}
+	before, errBefore := decodeSnapshot(e.before)
+	after, errAfter := decodeSnapshot(e.after)
+	if errBefore != nil || errAfter != nil || before == nil || after == nil {
+		return line
+	}
+	if changes := changedFields(before, after); len(changes) > 0 {
+		line += ": " + strings.Join(changes, ", ")
+	}
+	return line
+}
+
+func changedFields(before, after *itemSnapshot) []string {
+	var changes []string
+	if before.Owner != after.Owner {
+		changes = append(changes, fmt.Sprintf("owner <@%s> → <@%s>", before.Owner, after.Owner))
+	}
+	if before.Name != after.Name {
+		changes = append(changes, fmt.Sprintf("name %s → %s", before.Name, after.Name))
+	}
+	if before.Due != after.Due {
+		changes = append(changes, fmt.Sprintf("due %s → %s", before.Due, after.Due))
+	}
+	if before.Done != after.Done {
+		changes = append(changes, fmt.Sprintf("done %t → %t", before.Done, after.Done))
+	}
+	if before.Accepted != after.Accepted {
+		changes = append(changes, fmt.Sprintf("accepted %t → %t", before.Accepted, after.Accepted))
+	}
+	ackedBefore, _ := ackedCount(before)
+	if ackedAfter, total := ackedCount(after); ackedAfter != ackedBefore {
+		changes = append(changes, fmt.Sprintf("acknowledged %d/%d", ackedAfter, total))
+	}
+	return changes
+}
+
+func ackedCount(s *itemSnapshot) (acked, total int) {
+	for _, r := range s.Recipients {
+		if r.Acked {
+			acked += 1
+		}
+	}
+	return acked, len(s.Recipients)
+}
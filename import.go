@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	importMaxFileSize = 1 << 20
+	importTimeout     = 30 * time.Second
+	importDateFormat  = "02-01-06 15:04"
+)
+
+type (
+	importFile struct {
+		name string
+		data []byte
+		err  error
+	}
+
+	// An importEntry is turned into the command it stands for,
+	// validated the same way as a typed command
+	importEntry struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+		Due  string `json:"due"`
+	}
+)
+
+// Downloads of the attachments give up after importTimeout
+var importClient = &http.Client{Timeout: importTimeout}
+
+// The command is built without going through the bot's syntax,
+// names may hold any character, commas included
+func (e importEntry) command() (command, error) {
+	name, tags, p := splitLabels(e.Name)
+	if name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	var due date
+	hasDueDate := strings.TrimSpace(e.Due) != ""
+	if hasDueDate {
+		var err parserError
+		if due, err = parseDateText(e.Due); !err.isOK() {
+			return nil, fmt.Errorf("invalid date %s: %s", e.Due, err.details)
+		}
+	}
+
+	switch strings.ToLower(e.Kind) {
+	case "reminder":
+		if !hasDueDate {
+			return nil, fmt.Errorf("a reminder needs a due date")
+		}
+		return &remindMeCommand{
+			identifier: name,
+			tags:       tags,
+			priority:   p,
+			date:       due,
+		}, nil
+	case "task":
+		return &staffMeCommand{
+			identifier: name,
+			tags:       tags,
+			priority:   p,
+			hasDueDate: hasDueDate,
+			date:       due,
+		}, nil
+	}
+	return nil, fmt.Errorf("kind must be either reminder or task")
+}
+
+func fetchAttachments(attachments []*discordgo.MessageAttachment) []importFile {
+	files := make([]importFile, 0, len(attachments))
+	for _, attachment := range attachments {
+		file := importFile{name: attachment.Filename}
+		if attachment.Size > importMaxFileSize {
+			file.err = fmt.Errorf("file is bigger than %d bytes", importMaxFileSize)
+			files = append(files, file)
+			continue
+		}
+
+		resp, err := importClient.Get(attachment.URL)
+		if err != nil {
+			file.err = err
+			files = append(files, file)
+			continue
+		}
+		file.data, file.err = io.ReadAll(io.LimitReader(resp.Body, importMaxFileSize))
+		resp.Body.Close()
+		if file.err == nil && resp.StatusCode != http.StatusOK {
+			file.err = fmt.Errorf("download failed with status %s", resp.Status)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func parseImportFile(file importFile) ([]importEntry, error) {
+	switch strings.ToLower(filepath.Ext(file.name)) {
+	case ".ics":
+		return parseICSEntries(file.data)
+	case ".json":
+		return parseJSONEntries(file.data)
+	case ".csv":
+		return parseCSVEntries(file.data)
+	}
+	return nil, fmt.Errorf("unsupported file type")
+}
+
+func parseJSONEntries(data []byte) (entries []importEntry, err error) {
+	err = json.Unmarshal(data, &entries)
+	return
+}
+
+// Expected columns are: kind, name, due.
+// The header row is optional
+func parseCSVEntries(data []byte) (entries []importEntry, err error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return
+	}
+
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && strings.EqualFold(record[0], "kind") {
+			continue
+		}
+		if len(record) < 2 {
+			err = fmt.Errorf("line %d: expected at least 2 columns, got %d", i+1, len(record))
+			return
+		}
+		entry := importEntry{Kind: record[0], Name: record[1]}
+		if len(record) > 2 {
+			entry.Due = record[2]
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// Only VEVENT (imported as reminders) and VTODO (imported as tasks)
+// components are read, everything else is ignored, the components
+// nested in them such as VALARM included
+func parseICSEntries(data []byte) (entries []importEntry, err error) {
+	lines := unfoldICSLines(data)
+
+	var current *importEntry
+	// Components opened inside the current entry and not closed yet
+	var nested int
+	for _, line := range lines {
+		sep := strings.IndexByte(line, ':')
+		if sep == -1 {
+			continue
+		}
+		name, params := line[:sep], ""
+		if paramStart := strings.IndexByte(name, ';'); paramStart != -1 {
+			name, params = name[:paramStart], name[paramStart+1:]
+		}
+		name, value := strings.ToUpper(name), line[sep+1:]
+		if nested > 0 && name != "BEGIN" && name != "END" {
+			continue
+		}
+
+		switch name {
+		case "BEGIN":
+			switch {
+			case current != nil:
+				nested += 1
+			case strings.ToUpper(value) == "VEVENT":
+				current = &importEntry{Kind: "reminder"}
+			case strings.ToUpper(value) == "VTODO":
+				current = &importEntry{Kind: "task"}
+			}
+
+		case "END":
+			switch component := strings.ToUpper(value); {
+			case current == nil:
+			case nested > 0:
+				nested -= 1
+			case component == "VEVENT" || component == "VTODO":
+				entries = append(entries, *current)
+				current = nil
+			}
+
+		case "SUMMARY":
+			if current != nil {
+				current.Name = unescapeICSText(value)
+			}
+
+		case "DTSTART", "DUE":
+			if current == nil {
+				continue
+			}
+			if current.Kind == "reminder" && name == "DUE" {
+				continue
+			}
+			var due time.Time
+			due, err = parseICSTime(value, params)
+			if err != nil {
+				return
+			}
+			current.Due = due.Format(importDateFormat)
+		}
+	}
+	return
+}
+
+func unfoldICSLines(data []byte) (lines []string) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+func unescapeICSText(s string) string {
+	r := strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`)
+	return strings.TrimSpace(r.Replace(s))
+}
+
+func parseICSTime(value, params string) (result time.Time, err error) {
+	loc := time.Local
+	for _, param := range strings.Split(params, ";") {
+		if strings.HasPrefix(strings.ToUpper(param), "TZID=") {
+			if l, lerr := time.LoadLocation(param[len("TZID="):]); lerr == nil {
+				loc = l
+			}
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		result, err = time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		result, err = time.ParseInLocation("20060102T150405", value, loc)
+	default:
+		result, err = time.ParseInLocation("20060102", value, loc)
+	}
+	result = result.In(time.Local)
+	return
+}
+
+func importedNames(items []item) []string {
+	names := make([]string, len(items))
+	for i := range items {
+		names[i] = items[i].name
+	}
+	return names
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+// The text must be a valid tokenTag
+func makeTag(text string) string {
+	return strings.ToLower(strings.TrimPrefix(text, "#"))
+}
+
+// Separates the #tags and the priority from the words of a name
+func splitLabels(identifier string) (name string, tags []string, p priority) {
+	words := strings.Fields(identifier)
+	nameWords := make([]string, 0, len(words))
+	for _, word := range words {
+		if len(word) > 1 && word[0] == '#' {
+			tags = appendTag(tags, makeTag(word))
+		} else if wordPriority, exist := priorityWords[strings.ToLower(word)]; exist {
+			p = wordPriority
+		} else {
+			nameWords = append(nameWords, word)
+		}
+	}
+	return strings.Join(nameWords, " "), tags, p
+}
+
+func appendTag(tags []string, tag string) []string {
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+func (it *item) hasTag(tag string) bool {
+	for _, t := range it.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends the tags of the item to the detail shown in briefings
+func tagsDetail(detail string, it *item) string {
+	if len(it.tags) == 0 {
+		return detail
+	}
+	tags := "#" + strings.Join(it.tags, " #")
+	if detail == "" {
+		return tags
+	}
+	return detail + " " + tags
+}
+
+// Names of the lists used by the items, sorted
+func listNames(lists ...[]item) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, items := range lists {
+		for _, it := range items {
+			if it.list != "" && !seen[it.list] {
+				seen[it.list] = true
+				names = append(names, it.list)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *app) initLabels() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS item_tags (
+		item_id INTEGER NOT NULL,
+		tag TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS item_lists (
+		item_id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL
+	);`)
+}
+
+// Must be called once the items are loaded
+func (a *app) loadLabels() error {
+	tags := make(map[int][]string)
+	results, err := a.db.Query("SELECT item_id, tag FROM item_tags;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	err = results.Iterate(func(d types.Document) error {
+		var itemID int
+		var tag string
+
+		err := document.Scan(d, &itemID, &tag)
+		tags[itemID] = append(tags[itemID], tag)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	lists := make(map[int]string)
+	listResults, err := a.db.Query("SELECT item_id, name FROM item_lists;")
+	if err != nil {
+		return err
+	}
+	defer listResults.Close()
+	err = listResults.Iterate(func(d types.Document) error {
+		var itemID int
+		var name string
+
+		err := document.Scan(d, &itemID, &name)
+		lists[itemID] = name
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var label func(items []item)
+	label = func(items []item) {
+		for i := range items {
+			items[i].tags = tags[items[i].id]
+			items[i].list = lists[items[i].id]
+			label(items[i].subtasks)
+		}
+	}
+	for _, u := range a.users {
+		label(u.reminders)
+		label(u.tasks)
+	}
+	return nil
+}
+
+func insertLabels(tx *genji.Tx, it *item) error {
+	for _, tag := range it.tags {
+		err := tx.Exec("INSERT INTO item_tags (item_id, tag) VALUES (?, ?);", it.id, tag)
+		if err != nil {
+			return err
+		}
+	}
+	if it.list == "" {
+		return nil
+	}
+	return tx.Exec("INSERT INTO item_lists (item_id, name) VALUES (?, ?);", it.id, it.list)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -10,94 +11,89 @@ import (
 	"github.com/genjidb/genji"
 )
 
-var botToken string
-
 func main() {
-	tokenStr := flag.String("key", "invalid key", "the remindMeBot token string")
-	botToken = *tokenStr
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		runREPL(os.Args[2:])
+		return
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	fs.Parse(os.Args[1:])
+	flags.collect(fs)
+
+	config, err := loadConfig(flags, true)
+	if flags.checkConfig {
+		fmt.Print(config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	db, err := genji.Open("./data/remindme")
+	db, err := genji.Open(config.app.Database)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	session, err := discordgo.New("Bot " + botToken)
+	session, err := discordgo.New("Bot " + config.token)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	session.AddHandler(onMessage)
-	session.AddHandler(onReaction)
-
-	theApp = &app{
-		s:               session,
-		remindChannelID: "649758541376127015",
+	a := &app{
+		s:               &discordMessenger{s: session},
+		remindChannelID: config.app.RemindChannel,
 		db:              db,
 		shouldClose:     make(chan bool),
+		outboxWake:      make(chan bool, 1),
 		users:           make(map[string]*user),
+		clock:           realClock{},
+		config:          config.app,
+		configPath:      config.configPath,
+		configFlags:     flags,
 	}
-	theApp.init()
-	defer theApp.shutdown()
+	a.init()
+	session.AddHandler(a.onMessage)
+	session.AddHandler(a.onReaction)
 
-	err = theApp.s.Open()
+	err = session.Open()
 	if err != nil {
 		log.Fatalf("Cannot open the session: %v", err)
 	}
-	defer theApp.s.Close()
-	defer theApp.db.Close()
-	go theApp.run()
+	defer session.Close()
+	defer a.db.Close()
+	if a.config.API.Enabled {
+		a.startAPI()
+		defer a.api.Close()
+	}
+	stopWebhooks := make(chan bool)
+	go a.runWebhooks(stopWebhooks)
+	stopOutbox := make(chan bool)
+	go a.runOutbox(stopOutbox)
+	stopConfig := make(chan bool)
+	go a.watchConfig(stopConfig)
+	go a.run()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, os.Kill)
 	<-stop
-	theApp.shouldClose <- true
+	a.shouldClose <- true
+	stopWebhooks <- true
+	stopOutbox <- true
+	stopConfig <- true
 	log.Println("Graceful shutdown")
 }
 
-func onMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return
-	}
-	cmd, err := parseCommand(m.Content)
-	if !err.isOK() {
-		theApp.handleError(m.ChannelID, err)
-		return
-	}
-	theApp.handleCommand(m.Message, cmd)
+// Gateway handlers, bound to the app of the session
+func (a *app) onMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	a.handleMessage(m)
 }
 
-func onReaction(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
-	if m.UserID == s.State.User.ID {
-		return
-	}
-	if m.Emoji.Name != "☑" {
-		return
-	}
-	msg, _ := s.ChannelMessage(m.ChannelID, m.MessageID)
-	if !msg.Author.Bot {
-		return
-	}
-	if len(msg.Embeds) == 1 {
-		var kind itemKind
-		e := msg.Embeds[0]
-		switch e.Title {
-		case reminderAlarm:
-			kind = itemReminder
-		case taskAlarm:
-			kind = itemTask
-		default:
-			return
-		}
-
-		var start int
-		for i := len(e.Description) - 4; i >= 0; i -= 1 {
-			if e.Description[i] == '*' {
-				start = i + 1
-				break
-			}
-		}
-		itemName := e.Description[start : len(e.Description)-3]
-
-		theApp.removeItem(m.Member.User, itemName, kind)
-	}
+func (a *app) onReaction(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	a.handleReaction(m)
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type (
+	// Everything the app sends goes through a messenger,
+	// each frontend renders the results in its own format
+	messenger interface {
+		botUserID() string
+		sendText(channelID, content string) (messageID string, err error)
+		sendResult(channelID string, r *commandResult) (messageID string, err error)
+		addReaction(channelID, messageID, emoji string) error
+		turnPage(channelID, messageID, userID string, forward bool) error
+		directChannel(userID string) (channelID string, err error)
+	}
+
+	discordMessenger struct {
+		s     *discordgo.Session
+		pages pager
+	}
+
+	// Prints everything the app would send to Discord
+	terminalMessenger struct {
+		out       io.Writer
+		format    renderFormat
+		mut       sync.Mutex
+		messageID int
+	}
+)
+
+func (d *discordMessenger) botUserID() string {
+	return d.s.State.User.ID
+}
+
+func (d *discordMessenger) sendText(channelID, content string) (string, error) {
+	msg, err := d.s.ChannelMessageSend(channelID, content)
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// Long results are paginated, when the ◀ ▶ reactions
+// cannot be added the other pages are sent as messages
+func (d *discordMessenger) sendResult(channelID string, r *commandResult) (string, error) {
+	pages := renderEmbeds(r)
+	msg, err := d.s.ChannelMessageSendEmbed(channelID, pages[0])
+	if err != nil {
+		return "", err
+	}
+	if len(pages) == 1 {
+		return msg.ID, nil
+	}
+
+	d.pages.add(msg.ID, pages)
+	err = d.s.MessageReactionAdd(channelID, msg.ID, prevPageEmoji)
+	if err == nil {
+		err = d.s.MessageReactionAdd(channelID, msg.ID, nextPageEmoji)
+	}
+	if err != nil {
+		log.Println("Pagination unavailable: ", err)
+		for _, page := range pages[1:] {
+			if _, err := d.s.ChannelMessageSendEmbed(channelID, page); err != nil {
+				return msg.ID, err
+			}
+		}
+	}
+	return msg.ID, nil
+}
+
+func (d *discordMessenger) addReaction(channelID, messageID, emoji string) error {
+	return d.s.MessageReactionAdd(channelID, messageID, emoji)
+}
+
+// The reaction of the user is removed so they can flip again
+// The reaction of the user is removed even on the first or last page,
+// so the next one is seen
+func (d *discordMessenger) turnPage(channelID, messageID, userID string, forward bool) error {
+	page, known := d.pages.turn(messageID, forward)
+	if !known {
+		return nil
+	}
+	if page != nil {
+		if _, err := d.s.ChannelMessageEditEmbed(channelID, messageID, page); err != nil {
+			return err
+		}
+	}
+	emoji := prevPageEmoji
+	if forward {
+		emoji = nextPageEmoji
+	}
+	return d.s.MessageReactionRemove(channelID, messageID, emoji, userID)
+}
+
+func (d *discordMessenger) directChannel(userID string) (string, error) {
+	channel, err := d.s.UserChannelCreate(userID)
+	if err != nil {
+		return "", err
+	}
+	return channel.ID, nil
+}
+
+func (t *terminalMessenger) botUserID() string {
+	return ""
+}
+
+func (t *terminalMessenger) sendText(channelID, content string) (string, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.format != formatJSON {
+		fmt.Fprintln(t.out, content)
+		return t.nextMessageID(), nil
+	}
+	data, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{content})
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(t.out, string(data))
+	return t.nextMessageID(), nil
+}
+
+func (t *terminalMessenger) sendResult(channelID string, r *commandResult) (string, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	fmt.Fprint(t.out, render(r, t.format))
+	return t.nextMessageID(), nil
+}
+
+func (t *terminalMessenger) addReaction(channelID, messageID, emoji string) error {
+	return nil
+}
+
+func (t *terminalMessenger) turnPage(channelID, messageID, userID string, forward bool) error {
+	return nil
+}
+
+func (t *terminalMessenger) directChannel(userID string) (string, error) {
+	return "dm-" + userID, nil
+}
+
+func (t *terminalMessenger) nextMessageID() string {
+	t.messageID += 1
+	return strconv.Itoa(t.messageID)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const (
+	reminderEscalated = "Reminder Escalated"
+	reminderMissed    = "Reminder Missed"
+
+	escalateByDM         = "dm"
+	defaultEscalateAfter = 3
+)
+
+type (
+	// How an overdue reminder is nagged. The intervals back off,
+	// the last one repeats. Reminders without a policy are nagged
+	// every ReminderFrequency minutes until acknowledged
+	nagPolicy struct {
+		intervals []time.Duration
+		// 0 nags until acknowledged
+		maxNags int
+		// escalateByDM, the Discord ID of a user, or empty
+		escalateTo    string
+		escalateAfter int
+	}
+)
+
+func (p *nagPolicy) String() string {
+	var parts []string
+	if len(p.intervals) > 0 {
+		intervals := make([]string, len(p.intervals))
+		for i, interval := range p.intervals {
+			intervals[i] = formatInterval(interval)
+		}
+		parts = append(parts, "nagged after "+strings.Join(intervals, ", "))
+	}
+	if p.maxNags > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d times", p.maxNags))
+	}
+	switch p.escalateTo {
+	case "":
+	case escalateByDM:
+		parts = append(parts, fmt.Sprintf("by direct message after %d", p.escalateAfter))
+	default:
+		parts = append(parts, fmt.Sprintf("escalated to <@%s> after %d", p.escalateTo, p.escalateAfter))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// 2h0m0s is written 2h
+func formatInterval(d time.Duration) string {
+	text := d.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+func parseIntervals(text string) ([]time.Duration, error) {
+	var intervals []time.Duration
+	for _, field := range strings.Fields(text) {
+		interval, err := time.ParseDuration(field)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval %s is not positive", field)
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals, nil
+}
+
+// Time to wait before the next nag of the reminder
+func (a *app) nagInterval(reminder *item) time.Duration {
+	if reminder.nag == nil || len(reminder.nag.intervals) == 0 {
+		return time.Duration(a.config.ReminderFrequency) * time.Minute
+	}
+	intervals := reminder.nag.intervals
+	if reminder.nagCount < len(intervals) {
+		return intervals[reminder.nagCount]
+	}
+	return intervals[len(intervals)-1]
+}
+
+// Nags the owner of the overdue reminder, escalates it,
+// or gives up once it was nagged the maximum number of times.
+// Nags held for the end of the quiet hours are not counted,
+// and the reminder is only missed once the user can hear of it.
+// Returns false when the nag could not be queued.
+// Must run on the state goroutine
+func (a *app) nagReminder(u *user, reminder *item) bool {
+	held := u.holds(reminder, a.clock.now())
+	if reminder.nag != nil && reminder.nag.maxNags > 0 && reminder.nagCount >= reminder.nag.maxNags {
+		if !held {
+			a.missReminder(u, reminder)
+		}
+		return true
+	}
+
+	description := fmt.Sprintf("Have you done **%s**?", reminder.name)
+	if !a.sendAlarm(u, reminder, description, true) {
+		return false
+	}
+	if held {
+		return true
+	}
+	reminder.nagCount += 1
+	err := a.db.Exec("UPDATE items SET nag_count = ? WHERE id = ?;", reminder.nagCount, reminder.id)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	a.escalateReminder(u, reminder, description)
+	return true
+}
+
+// Escalated once, by the nag reaching the threshold of the policy.
+// Must run on the state goroutine
+func (a *app) escalateReminder(u *user, reminder *item, description string) {
+	policy := reminder.nag
+	if policy == nil || policy.escalateTo == "" || reminder.nagCount != policy.escalateAfter {
+		return
+	}
+
+	if policy.escalateTo == escalateByDM {
+		a.enqueue(directMessage(u.id, &commandResult{
+			title:       reminderAlarm,
+			description: description,
+		}, "☑").about(reminder.id))
+		return
+	}
+
+	a.enqueue(
+		textMessage(a.remindChannelID, fmt.Sprintf("<@%s>", policy.escalateTo)),
+		resultMessage(a.remindChannelID, &commandResult{
+			title: reminderEscalated,
+			description: fmt.Sprintf(
+				"<@%s> has not acknowledged **%s** after %d reminders.",
+				u.id,
+				reminder.name,
+				reminder.nagCount,
+			),
+		}, "☑").about(reminder.id),
+	)
+}
+
+// A missed reminder is kept, without nags, until it is removed
+// or acknowledged on one of its alarms.
+// Must run on the state goroutine
+func (a *app) missReminder(u *user, reminder *item) {
+	reminder.missed = true
+	err := a.db.Exec("UPDATE items SET missed = 1 WHERE id = ?;", reminder.id)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	a.enqueue(resultMessage(a.remindChannelID, &commandResult{
+		title:       reminderMissed,
+		description: fmt.Sprintf("<@%s> missed **%s** after %d reminders.", u.id, reminder.name, reminder.nagCount),
+	}, "☑").about(reminder.id))
+	a.emitEvent(eventReminderMissed, u, reminder)
+}
+
+func (a *app) initNagPolicies() error {
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS item_nag_policies (
+		item_id INTEGER PRIMARY KEY,
+		intervals TEXT NOT NULL,
+		max_nags INTEGER NOT NULL,
+		escalate_to TEXT NOT NULL,
+		escalate_after INTEGER NOT NULL
+	);`)
+}
+
+// Must be called once the items are loaded
+func (a *app) loadNagPolicies() error {
+	byItem := make(map[int]*nagPolicy)
+	results, err := a.db.Query("SELECT item_id, intervals, max_nags, escalate_to, escalate_after FROM item_nag_policies;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	err = results.Iterate(func(d types.Document) error {
+		var itemID int
+		var intervals string
+		policy := &nagPolicy{}
+
+		err := document.Scan(d, &itemID, &intervals, &policy.maxNags, &policy.escalateTo, &policy.escalateAfter)
+		if err != nil {
+			return err
+		}
+		policy.intervals, err = parseIntervals(intervals)
+		byItem[itemID] = policy
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range a.users {
+		for i := range u.reminders {
+			u.reminders[i].nag = byItem[u.reminders[i].id]
+		}
+	}
+	return nil
+}
+
+func insertNagPolicy(tx *genji.Tx, it *item) error {
+	if it.nag == nil {
+		return nil
+	}
+	intervals := make([]string, len(it.nag.intervals))
+	for i, interval := range it.nag.intervals {
+		intervals[i] = interval.String()
+	}
+	return tx.Exec(
+		"INSERT INTO item_nag_policies (item_id, intervals, max_nags, escalate_to, escalate_after) VALUES (?, ?, ?, ?, ?);",
+		it.id,
+		strings.Join(intervals, " "),
+		it.nag.maxNags,
+		it.nag.escalateTo,
+		it.nag.escalateAfter,
+	)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const (
+	outboxBaseBackoff  = 5 * time.Second
+	outboxMaxBackoff   = 10 * time.Minute
+	outboxMaxAttempts  = 8
+	outboxBatchSize    = 50
+	outboxRateWindow   = 5 * time.Second
+	defaultChannelRate = 5
+)
+
+type (
+	// Message waiting in the outbox. A message without a channel
+	// is sent to the user by direct message
+	outboxMessage struct {
+		id          int
+		channelID   string
+		userID      string
+		content     string
+		result      string
+		reactions   string
+		messageID   string
+		attempts    int
+		nextAttempt int64
+		// Items the message notifies about, reactions on it act on the items
+		itemIDs []int
+	}
+
+	// Messages sent per channel within the last rate window
+	channelLimiter struct {
+		mut  sync.Mutex
+		sent map[string][]time.Time
+	}
+
+	outboxResult struct {
+		Title       string          `json:"title"`
+		Description string          `json:"description"`
+		Status      int             `json:"status"`
+		Sections    []outboxSection `json:"sections"`
+	}
+
+	outboxSection struct {
+		Name  string       `json:"name"`
+		Icon  int          `json:"icon"`
+		Text  string       `json:"text"`
+		Items []outboxItem `json:"items"`
+	}
+
+	outboxItem struct {
+		Mark      int    `json:"mark"`
+		Name      string `json:"name"`
+		Detail    string `json:"detail"`
+		Highlight bool   `json:"highlight"`
+	}
+)
+
+func (m *outboxMessage) channelKey() string {
+	if m.channelID == "" {
+		return "dm:" + m.userID
+	}
+	return m.channelID
+}
+
+func encodeResult(r *commandResult) string {
+	result := outboxResult{
+		Title:       r.title,
+		Description: r.description,
+		Status:      int(r.status),
+	}
+	for _, section := range r.sections {
+		s := outboxSection{
+			Name: section.name,
+			Icon: int(section.icon),
+			Text: section.text,
+		}
+		for _, it := range section.items {
+			s.Items = append(s.Items, outboxItem{
+				Mark:      int(it.mark),
+				Name:      it.name,
+				Detail:    it.detail,
+				Highlight: it.highlight,
+			})
+		}
+		result.Sections = append(result.Sections, s)
+	}
+	// Strings, numbers and booleans always marshal
+	data, _ := json.Marshal(result)
+	return string(data)
+}
+
+func decodeResult(data string) (*commandResult, error) {
+	var result outboxResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return nil, err
+	}
+	r := &commandResult{
+		title:       result.Title,
+		description: result.Description,
+		status:      resultStatus(result.Status),
+	}
+	for _, s := range result.Sections {
+		section := resultSection{
+			name: s.Name,
+			icon: resultIcon(s.Icon),
+			text: s.Text,
+		}
+		for _, it := range s.Items {
+			section.items = append(section.items, resultItem{
+				mark:      resultMark(it.Mark),
+				name:      it.Name,
+				detail:    it.Detail,
+				highlight: it.Highlight,
+			})
+		}
+		r.sections = append(r.sections, section)
+	}
+	return r, nil
+}
+
+// Limit is the number of messages allowed per channel
+// every outboxRateWindow, 0 for no limit
+func (l *channelLimiter) allow(key string, now time.Time, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.sent == nil {
+		l.sent = make(map[string][]time.Time)
+	}
+	recent := l.sent[key][:0]
+	for _, sent := range l.sent[key] {
+		if now.Sub(sent) < outboxRateWindow {
+			recent = append(recent, sent)
+		}
+	}
+	if len(recent) >= limit {
+		l.sent[key] = recent
+		return false
+	}
+	l.sent[key] = append(recent, now)
+	return true
+}
+
+func (a *app) initOutbox() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+		id INTEGER PRIMARY KEY,
+		channel_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		content TEXT NOT NULL,
+		result TEXT NOT NULL,
+		reactions TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		next_attempt INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec(`CREATE TABLE IF NOT EXISTS outbox_dead_letters (
+		id INTEGER PRIMARY KEY,
+		channel_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		content TEXT NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL,
+		failed_at TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	// A message notifies about several items when it batches them
+	err = a.db.Exec(`CREATE TABLE IF NOT EXISTS notifications (
+		message_id TEXT NOT NULL,
+		item_id INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	err = a.db.Exec("CREATE INDEX IF NOT EXISTS notifications_message_idx ON notifications (message_id);")
+	if err != nil {
+		return err
+	}
+	return a.db.Exec("CREATE SEQUENCE IF NOT EXISTS outbox_seq;")
+}
+
+func textMessage(channelID, content string) *outboxMessage {
+	return &outboxMessage{channelID: channelID, content: content}
+}
+
+// The reactions are added to the message once it is sent
+func resultMessage(channelID string, r *commandResult, reactions ...string) *outboxMessage {
+	return &outboxMessage{channelID: channelID, result: encodeResult(r), reactions: strings.Join(reactions, " ")}
+}
+
+func directMessage(userID string, r *commandResult, reactions ...string) *outboxMessage {
+	return &outboxMessage{userID: userID, result: encodeResult(r), reactions: strings.Join(reactions, " ")}
+}
+
+func (m *outboxMessage) about(itemIDs ...int) *outboxMessage {
+	m.itemIDs = append(m.itemIDs, itemIDs...)
+	return m
+}
+
+// Item IDs are stored separated by spaces, like the reactions
+func joinIDs(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, " ")
+}
+
+func splitIDs(text string) (ids []int) {
+	for _, field := range strings.Fields(text) {
+		if id, err := strconv.Atoi(field); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Queues the messages, all of them or none
+func (a *app) enqueue(messages ...*outboxMessage) error {
+	err := a.db.Update(func(tx *genji.Tx) error {
+		for _, msg := range messages {
+			err := tx.Exec(
+				"INSERT INTO outbox (id, channel_id, user_id, content, result, reactions, message_id, attempts, next_attempt, item_ids) VALUES (NEXT VALUE FOR outbox_seq, ?, ?, ?, ?, ?, '', 0, ?, ?);",
+				msg.channelID,
+				msg.userID,
+				msg.content,
+				msg.result,
+				msg.reactions,
+				a.clock.now().Unix(),
+				joinIDs(msg.itemIDs),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return err
+	}
+	select {
+	case a.outboxWake <- true:
+	default:
+	}
+	return nil
+}
+
+func (a *app) runOutbox(stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-a.outboxWake:
+			a.deliverOutbox()
+		case <-time.After(sleepTime):
+			a.deliverOutbox()
+		}
+	}
+}
+
+// Sends the queued messages in order. A message that cannot be sent
+// holds back the next ones of its channel until it is retried
+func (a *app) deliverOutbox() {
+	a.outboxMut.Lock()
+	defer a.outboxMut.Unlock()
+
+	// In primary key order, see deliverWebhooks
+	messages := make([]outboxMessage, 0, outboxBatchSize)
+	result, err := a.db.Query(fmt.Sprintf(
+		"SELECT id, channel_id, user_id, content, result, reactions, message_id, attempts, next_attempt, item_ids FROM outbox LIMIT %d;",
+		outboxBatchSize,
+	))
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return
+	}
+	err = result.Iterate(func(d types.Document) error {
+		var msg outboxMessage
+		var itemIDs string
+		err := document.Scan(d, &msg.id, &msg.channelID, &msg.userID, &msg.content, &msg.result, &msg.reactions, &msg.messageID, &msg.attempts, &msg.nextAttempt, &itemIDs)
+		msg.itemIDs = splitIDs(itemIDs)
+		messages = append(messages, msg)
+		return err
+	})
+	result.Close()
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return
+	}
+
+	var rate int
+	a.do(func() { rate = a.config.Outbox.ChannelRate })
+
+	now := a.clock.now()
+	blocked := make(map[string]bool)
+	for i := range messages {
+		msg := &messages[i]
+		key := msg.channelKey()
+		if blocked[key] || msg.nextAttempt > now.Unix() || !a.outboxLimiter.allow(key, now, rate) {
+			blocked[key] = true
+			continue
+		}
+
+		sendErr := a.deliver(msg)
+		if sendErr == nil {
+			err = a.db.Exec("DELETE FROM outbox WHERE id = ?;", msg.id)
+		} else {
+			blocked[key] = true
+			msg.attempts += 1
+			if msg.attempts >= outboxMaxAttempts {
+				log.Printf("Dead-lettering message %d to %s: %v", msg.id, key, sendErr)
+				err = a.deadLetter(msg, sendErr)
+			} else {
+				err = a.db.Exec(
+					"UPDATE outbox SET attempts = ?, next_attempt = ?, message_id = ? WHERE id = ?;",
+					msg.attempts,
+					now.Add(outboxBackoff(msg.attempts)).Unix(),
+					msg.messageID,
+					msg.id,
+				)
+			}
+		}
+		if err != nil {
+			log.Println("DB access failure: ", err)
+		}
+	}
+}
+
+// A message already sent by a previous attempt only gets its reactions
+func (a *app) deliver(msg *outboxMessage) error {
+	channelID := msg.channelID
+	if channelID == "" {
+		var err error
+		if channelID, err = a.s.directChannel(msg.userID); err != nil {
+			return err
+		}
+	}
+
+	if msg.messageID == "" {
+		var err error
+		if msg.result == "" {
+			msg.messageID, err = a.s.sendText(channelID, msg.content)
+		} else {
+			var r *commandResult
+			if r, err = decodeResult(msg.result); err != nil {
+				return err
+			}
+			msg.messageID, err = a.s.sendResult(channelID, r)
+		}
+		if err != nil {
+			msg.messageID = ""
+			return err
+		}
+		for _, itemID := range msg.itemIDs {
+			err = a.db.Exec("INSERT INTO notifications (message_id, item_id) VALUES (?, ?);", msg.messageID, itemID)
+			if err != nil {
+				log.Println("DB access failure: ", err)
+			}
+		}
+	}
+	for _, emoji := range strings.Fields(msg.reactions) {
+		if err := a.s.addReaction(channelID, msg.messageID, emoji); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *app) deadLetter(msg *outboxMessage, sendErr error) error {
+	return a.db.Update(func(tx *genji.Tx) error {
+		err := tx.Exec(
+			"INSERT INTO outbox_dead_letters (id, channel_id, user_id, content, result, error, failed_at) VALUES (?, ?, ?, ?, ?, ?, ?);",
+			msg.id,
+			msg.channelID,
+			msg.userID,
+			msg.content,
+			msg.result,
+			sendErr.Error(),
+			a.clock.now().Format(timeFormat),
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM outbox WHERE id = ?;", msg.id)
+	})
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff << (attempts - 1)
+	if backoff > outboxMaxBackoff || backoff <= 0 {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// Items notified by the message, none for other messages
+func (a *app) notifiedItems(messageID string) (itemIDs []int) {
+	result, err := a.db.Query("SELECT item_id FROM notifications WHERE message_id = ?;", messageID)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return nil
+	}
+	defer result.Close()
+
+	err = result.Iterate(func(d types.Document) error {
+		var itemID int
+		err := document.Scan(d, &itemID)
+		itemIDs = append(itemIDs, itemID)
+		return err
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	return itemIDs
+}
+
+// Notifies again the items waiting for a reaction, the pending
+// assignments and the missed reminders. Overdue reminders get
+// a new nag on the first tick anyway
+func (a *app) renotify() {
+	for _, u := range a.users {
+		for i := range u.tasks {
+			if task := &u.tasks[i]; task.assignedBy != "" && !task.accepted {
+				a.notifyAssignment(u, task)
+			}
+		}
+		for i := range u.reminders {
+			if reminder := &u.reminders[i]; reminder.missed {
+				a.enqueue(resultMessage(a.remindChannelID, &commandResult{
+					title:       reminderMissed,
+					description: fmt.Sprintf("<@%s> missed **%s**.", u.id, reminder.name),
+				}, "☑").about(reminder.id))
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	prevPageEmoji = "◀️"
+	nextPageEmoji = "▶️"
+
+	// Older paginated messages can no longer be flipped
+	pagerCapacity = 100
+)
+
+type (
+	// Pages of the results too long for a single embed,
+	// flipped with the ◀ ▶ reactions
+	pager struct {
+		mut      sync.Mutex
+		messages map[string]*pagedMessage
+		order    []string
+	}
+
+	pagedMessage struct {
+		pages   []*discordgo.MessageEmbed
+		current int
+	}
+)
+
+func (p *pager) add(messageID string, pages []*discordgo.MessageEmbed) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.messages == nil {
+		p.messages = make(map[string]*pagedMessage)
+	}
+	if len(p.order) == pagerCapacity {
+		delete(p.messages, p.order[0])
+		p.order = p.order[1:]
+	}
+	p.messages[messageID] = &pagedMessage{pages: pages}
+	p.order = append(p.order, messageID)
+}
+
+// Returns the page to show, nil when the message already shows
+// its first or last page. known is false for the messages
+// the pager does not hold
+func (p *pager) turn(messageID string, forward bool) (page *discordgo.MessageEmbed, known bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	msg, exist := p.messages[messageID]
+	if !exist {
+		return nil, false
+	}
+	next := msg.current - 1
+	if forward {
+		next = msg.current + 1
+	}
+	if next < 0 || next >= len(msg.pages) {
+		return nil, true
+	}
+	msg.current = next
+	return msg.pages[next], true
+}
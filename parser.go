@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 type (
@@ -32,39 +36,8 @@ func parseCommand(input string) (result command, err parserError) {
 			break
 		}
 
-		if cmdKind, exist := commandKeywords[parser.current.text]; exist {
-			switch cmdKind {
-			case commandBriefMe:
-				result, err = parser.parseBriefMeCmd()
-				if !err.isOK() {
-					return
-				}
-
-			case commandRemindMe:
-				result, err = parser.parseRemindMeCmd()
-				if !err.isOK() {
-					return
-				}
-
-			case commandStaffMe:
-				result, err = parser.parseStaffMeCmd()
-				if !err.isOK() {
-					return
-				}
-
-			case commandRemoveMe:
-				result, err = parser.parseRemoveMeCmd()
-				if !err.isOK() {
-					return
-				}
-
-			case commandHelpMe:
-				result, err = parser.parseHelpMeCmd()
-				if !err.isOK() {
-					return
-				}
-			}
-		} else {
+		spec, exist := commands.lookup(strings.ToLower(parser.current.text))
+		if !exist {
 			err = parserError{
 				kind:    errorUnknownCommand,
 				token:   parser.current,
@@ -72,6 +45,9 @@ func parseCommand(input string) (result command, err parserError) {
 			}
 			return
 		}
+		if result, err = spec.parse(&parser); !err.isOK() {
+			return
+		}
 	}
 	return
 }
@@ -124,51 +100,333 @@ func (self *parser) consume() (result token, err parserError) {
 
 func (self *parser) parseBriefMeCmd() (result *briefMeCommand, err parserError) {
 	result = &briefMeCommand{
-		kind:     commandBriefMe,
 		token:    self.previous,
 		cmdToken: self.current,
 	}
-	return
+
+	// Optional filters: list name, #tag
+	for {
+		var next token
+		if next, err = self.peekNextToken(); !err.isOK() {
+			return
+		}
+		switch {
+		case next.kind == tokenTag:
+			self.consume()
+			result.tags = append(result.tags, makeTag(next.text))
+		case next.kind == tokenSeparator:
+			self.consume()
+		case self.peekListOption():
+			result.list, err = self.parseListOption()
+			if !err.isOK() {
+				return
+			}
+		default:
+			return
+		}
+	}
 }
 
 func (self *parser) parseRemindMeCmd() (result *remindMeCommand, err parserError) {
 	result = &remindMeCommand{
-		kind:     commandRemindMe,
 		token:    self.previous,
 		cmdToken: self.current,
 	}
+
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() {
+		return
+	}
+	if next.kind == tokenMention {
+		result.targets, err = self.parseTargets()
+		if !err.isOK() {
+			return
+		}
+	}
+
 	result.identifier, err = self.parseIdentifier()
 	if !err.isOK() {
 		return
 	}
+	result.identifier, result.tags, result.priority = splitLabels(result.identifier)
 	result.sepToken = self.current
 
 	result.date, err = self.parseDate()
+	if !err.isOK() {
+		return
+	}
+	result.list, result.nag, err = self.parseReminderOptions()
 	return
 }
 
+func (self *parser) parseRemindUsCmd() (result *remindUsCommand, err parserError) {
+	result = &remindUsCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	result.targets, err = self.parseTargets()
+	if !err.isOK() {
+		return
+	}
+
+	result.identifier, err = self.parseIdentifier()
+	if !err.isOK() {
+		return
+	}
+	result.identifier, result.tags, result.priority = splitLabels(result.identifier)
+	result.sepToken = self.current
+
+	result.date, err = self.parseDate()
+	if !err.isOK() {
+		return
+	}
+	result.list, result.nag, err = self.parseReminderOptions()
+	return
+}
+
+// Parses space separated mentions up to the next separator
+func (self *parser) parseTargets() (targets []mention, err parserError) {
+	for {
+		var next token
+		if next, err = self.consume(); !err.isOK() {
+			return
+		}
+		switch {
+		case next.kind == tokenMention:
+			targets = append(targets, makeMention(next.text))
+
+		case next.kind == tokenSeparator && len(targets) > 0:
+			return
+
+		default:
+			err = parserError{
+				kind:  errorInvalidSyntax,
+				token: next,
+				details: fmt.Sprintf(
+					"Expected %s got %s",
+					tokenKindString[tokenMention],
+					tokenKindString[next.kind],
+				),
+			}
+			return
+		}
+	}
+}
+
 func (self *parser) parseStaffMeCmd() (result *staffMeCommand, err parserError) {
 	result = &staffMeCommand{
-		kind:     commandStaffMe,
 		token:    self.previous,
 		cmdToken: self.current,
 	}
+
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() {
+		return
+	}
+	if next.kind == tokenMention {
+		result.targets, err = self.parseTargets()
+		if !err.isOK() {
+			return
+		}
+	}
+
 	result.identifier, err = self.parseIdentifier()
 	if !err.isOK() {
 		return
 	}
+	result.identifier, result.tags, result.priority = splitLabels(result.identifier)
+	result.parent, result.identifier, err = self.splitSubtask(result.identifier)
+	if !err.isOK() {
+		return
+	}
 
 	if self.current.kind == tokenSeparator {
 		result.sepToken = self.current
+		if self.peekListOption() {
+			result.list, err = self.parseListOption()
+			return
+		}
 		result.hasDueDate = true
 		result.date, err = self.parseDate()
+		if !err.isOK() {
+			return
+		}
+		result.list, err = self.parseTrailingList()
 	}
 	return
 }
 
+func (self *parser) peekListOption() bool {
+	next, err := self.peekNextToken()
+	return err.isOK() && next.kind == tokenIdentifier && next.text == "list"
+}
+
+// Parses `list: name`, the colon is optional
+func (self *parser) parseListOption() (list string, err parserError) {
+	self.consume()
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() {
+		return
+	}
+	if next.kind == tokenColon {
+		self.consume()
+	}
+
+	list = self.scanRaw()
+	if list == "" {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: "Expected the name of a list",
+		}
+	}
+	return
+}
+
+// Parses an optional `, list: name` after the last argument
+func (self *parser) parseTrailingList() (list string, err parserError) {
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() || next.kind != tokenSeparator {
+		return
+	}
+	self.consume()
+	if !self.peekListOption() {
+		next, _ = self.peekNextToken()
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   next,
+			details: fmt.Sprintf("Expected list got %s", tokenKindString[next.kind]),
+		}
+		return
+	}
+	return self.parseListOption()
+}
+
+// Parses the optional `, list: name`, `, nag: 10m 30m 2h`, `, max: 5`
+// and `, escalate: dm` or `, escalate: <@id> after 3` after the date
+// of a reminder, in any order. Colons are optional
+func (self *parser) parseReminderOptions() (list string, nag *nagPolicy, err parserError) {
+	for {
+		var next token
+		if next, err = self.peekNextToken(); !err.isOK() || next.kind != tokenSeparator {
+			return
+		}
+		self.consume()
+		if self.peekListOption() {
+			if list, err = self.parseListOption(); !err.isOK() {
+				return
+			}
+			continue
+		}
+
+		if next, err = self.consume(); !err.isOK() {
+			return
+		}
+		if next.kind != tokenIdentifier || !(next.text == "nag" || next.text == "max" || next.text == "escalate") {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   next,
+				details: fmt.Sprintf("Expected one of list, nag, max or escalate got %s", tokenKindString[next.kind]),
+			}
+			return
+		}
+		if nag == nil {
+			nag = &nagPolicy{escalateAfter: defaultEscalateAfter}
+		}
+		var colon token
+		if colon, err = self.peekNextToken(); !err.isOK() {
+			return
+		}
+		if colon.kind == tokenColon {
+			self.consume()
+		}
+
+		switch next.text {
+		case "nag":
+			raw := self.scanRaw()
+			intervals, intervalsErr := parseIntervals(raw)
+			if intervalsErr != nil || len(intervals) == 0 {
+				err = parserError{
+					kind:    errorInvalidSyntax,
+					token:   next,
+					details: fmt.Sprintf("Invalid nag intervals %q, expected durations such as 10m 30m 2h", raw),
+				}
+				return
+			}
+			nag.intervals = intervals
+		case "max":
+			raw := self.scanRaw()
+			maxNags, maxErr := strconv.Atoi(raw)
+			if maxErr != nil || maxNags <= 0 {
+				err = parserError{
+					kind:    errorInvalidSyntax,
+					token:   next,
+					details: fmt.Sprintf("Invalid maximum number of nags %q", raw),
+				}
+				return
+			}
+			nag.maxNags = maxNags
+		case "escalate":
+			if err = self.parseEscalation(nag); !err.isOK() {
+				return
+			}
+		}
+	}
+}
+
+// Parses `dm` or a user mention, followed by an optional `after n`
+func (self *parser) parseEscalation(nag *nagPolicy) (err parserError) {
+	var next token
+	if next, err = self.consume(); !err.isOK() {
+		return
+	}
+	switch {
+	case next.kind == tokenIdentifier && next.text == escalateByDM:
+		nag.escalateTo = escalateByDM
+	case next.kind == tokenMention && makeMention(next.text).kind == mentionUser:
+		nag.escalateTo = makeMention(next.text).id
+	default:
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   next,
+			details: "Expected dm or the mention of a user to escalate to",
+		}
+		return
+	}
+
+	if next, err = self.peekNextToken(); !err.isOK() || !(next.kind == tokenIdentifier && next.text == "after") {
+		return
+	}
+	self.consume()
+	if err = self.expectNext(tokenNumber); !err.isOK() {
+		return
+	}
+	after, _ := strconv.Atoi(self.current.text)
+	if after <= 0 {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: "Escalation needs at least one nag",
+		}
+		return
+	}
+	nag.escalateAfter = after
+	return
+}
+
+// Reads the input up to the next separator as is,
+// for names the lexer would split such as release-1.2
+func (self *parser) scanRaw() string {
+	self.skipWhitespaces()
+	start := self.lexer.current
+	for !self.isEOF() && self.peek() != ',' {
+		self.advance()
+	}
+	return strings.TrimSpace(string(self.lexer.input[start:self.lexer.current]))
+}
+
 func (self *parser) parseRemoveMeCmd() (result *removeMeCommand, err parserError) {
 	result = &removeMeCommand{
-		kind:     commandRemoveMe,
 		token:    self.previous,
 		cmdToken: self.current,
 	}
@@ -197,15 +455,376 @@ func (self *parser) parseRemoveMeCmd() (result *removeMeCommand, err parserError
 	}
 	result.sepToken = self.current
 	result.identifier, err = self.parseIdentifier()
+	if !err.isOK() {
+		return
+	}
+	result.parent, result.identifier, err = self.splitSubtask(result.identifier)
+	return
+}
+
+func (self *parser) parseDoneMeCmd() (result *doneMeCommand, err parserError) {
+	result = &doneMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	result.identifier, err = self.parseIdentifier()
+	if !err.isOK() {
+		return
+	}
+	result.parent, result.identifier, err = self.splitSubtask(result.identifier)
+	return
+}
+
+// Splits `task > subtask`, subtasks have no subtasks of their own
+func (self *parser) splitSubtask(identifier string) (parent, name string, err parserError) {
+	parts := strings.Split(identifier, ">")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+		if parts[i] == "" {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   self.current,
+				details: "Expected the name of a task on both sides of >",
+			}
+			return
+		}
+	}
+	switch len(parts) {
+	case 1:
+		name = parts[0]
+	case 2:
+		parent, name = parts[0], parts[1]
+	default:
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: "A subtask cannot have subtasks",
+		}
+	}
 	return
 }
 
 func (self *parser) parseHelpMeCmd() (result *helpMeCommand, err parserError) {
 	result = &helpMeCommand{
-		kind:     commandHelpMe,
 		token:    self.previous,
 		cmdToken: self.current,
 	}
+	var t token
+	if t, err = self.peekNextToken(); !err.isOK() || t.kind == tokenEOF {
+		return
+	}
+
+	// The command is written with or without its bang
+	if t.kind == tokenBang {
+		self.consume()
+	}
+	if err = self.expectNext(tokenIdentifier); !err.isOK() {
+		return
+	}
+	result.topic = strings.ToLower(self.current.text)
+	return
+}
+
+func (self *parser) parseUndoMeCmd() (result *undoMeCommand, err parserError) {
+	result = &undoMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	return
+}
+
+func (self *parser) parseHistoryMeCmd() (result *historyMeCommand, err parserError) {
+	result = &historyMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	result.identifier, err = self.parseIdentifier()
+	if !err.isOK() {
+		return
+	}
+	result.parent, result.identifier, err = self.splitSubtask(result.identifier)
+	return
+}
+
+func (self *parser) parseImportMeCmd() (result *importMeCommand, err parserError) {
+	result = &importMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	return
+}
+
+func (self *parser) parseReassignMeCmd() (result *reassignMeCommand, err parserError) {
+	result = &reassignMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	result.targets, err = self.parseTargets()
+	if !err.isOK() {
+		return
+	}
+	result.identifier, err = self.parseIdentifier()
+	return
+}
+
+func (self *parser) parseBriefTeamCmd() (result *briefTeamCommand, err parserError) {
+	result = &briefTeamCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	return
+}
+
+func (self *parser) parseSortMeCmd() (result *sortMeCommand, err parserError) {
+	result = &sortMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	if err = self.expectNext(tokenIdentifier); !err.isOK() {
+		return
+	}
+	order, exist := sortOrders[self.current.text]
+	if !exist {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: fmt.Sprintf("Unknown sort order %s, expected one of %s", self.current.text, sortOrderNames()),
+		}
+		return
+	}
+	result.order = order
+	return
+}
+
+func (self *parser) parseDigestMeCmd() (result *digestMeCommand, err parserError) {
+	result = &digestMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() {
+		return
+	}
+	if next.kind == tokenIdentifier && next.text == "off" {
+		self.consume()
+		result.off = true
+		return
+	}
+
+	if err = self.expectNext(tokenNumber); !err.isOK() {
+		return
+	}
+	hhmm, err := self.parseHHMM()
+	if !err.isOK() {
+		return
+	}
+	d := makeDate([3]token{}, hhmm)
+	if d.hour > 23 || d.min > 59 {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: fmt.Sprintf("Invalid time of the day %02d:%02d", d.hour, d.min),
+		}
+		return
+	}
+	result.schedule.hour, result.schedule.min = d.hour, d.min
+
+	for {
+		if next, err = self.peekNextToken(); !err.isOK() {
+			return
+		}
+		day, isDay := weekdayTokens[next.kind]
+		if !isDay {
+			break
+		}
+		self.consume()
+		result.schedule.days |= 1 << day
+	}
+	if result.schedule.days == 0 {
+		result.schedule.days = everyDay
+	}
+
+	if next.kind != tokenSeparator {
+		return
+	}
+	self.consume()
+	if err = self.expectNext(tokenMention); !err.isOK() {
+		return
+	}
+	channel := makeMention(self.current.text)
+	if channel.kind != mentionChannel {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: "The digest can only be sent to a channel",
+		}
+		return
+	}
+	result.schedule.channelID = channel.id
+	return
+}
+
+func (self *parser) parseQuietMeCmd() (result *quietMeCommand, err parserError) {
+	result = &quietMeCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() {
+		return
+	}
+	if next.kind == tokenIdentifier && next.text == "off" {
+		self.consume()
+		result.off = true
+		return
+	}
+
+	var bounds [2]int
+	var hhmm [2]token
+	for i := range bounds {
+		if i > 0 {
+			if err = self.expectNext(tokenDash); !err.isOK() {
+				return
+			}
+		}
+		if err = self.expectNext(tokenNumber); !err.isOK() {
+			return
+		}
+		if hhmm, err = self.parseHHMM(); !err.isOK() {
+			return
+		}
+		d := makeDate([3]token{}, hhmm)
+		if d.hour > 23 || d.min > 59 {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   self.current,
+				details: fmt.Sprintf("Invalid time of the day %02d:%02d", d.hour, d.min),
+			}
+			return
+		}
+		bounds[i] = d.hour*60 + d.min
+	}
+	result.hours = quietHours{start: bounds[0], end: bounds[1]}
+
+	if next, err = self.peekNextToken(); !err.isOK() || next.kind != tokenSeparator {
+		return
+	}
+	self.consume()
+	zone := self.scanRaw()
+	location, zoneErr := time.LoadLocation(zone)
+	if zone == "" || zoneErr != nil {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: fmt.Sprintf("Unknown time zone %q", zone),
+		}
+		return
+	}
+	result.timeZone = location
+	return
+}
+
+func (self *parser) parseDndCmd() (result *dndCommand, err parserError) {
+	result = &dndCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	raw := self.scanRaw()
+	if raw == "off" {
+		result.off = true
+		return
+	}
+	duration, durationErr := time.ParseDuration(raw)
+	if durationErr != nil || duration <= 0 {
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: fmt.Sprintf("Invalid duration %q, expected one such as 2h or 30m", raw),
+		}
+		return
+	}
+	result.duration = duration
+	return
+}
+
+func (self *parser) parseConfigureCmd() (result *configureCommand, err parserError) {
+	result = &configureCommand{
+		token:    self.previous,
+		cmdToken: self.current,
+	}
+	var next token
+	if next, err = self.peekNextToken(); !err.isOK() || next.kind == tokenEOF {
+		return
+	}
+	if err = self.expectNext(tokenIdentifier); !err.isOK() {
+		return
+	}
+	result.setting = strings.ToLower(self.current.text)
+	raw := self.scanRaw()
+
+	switch result.setting {
+	case configurePrefix:
+		if raw == "" || len(raw) > maxPrefixLength || strings.IndexFunc(raw, unicode.IsSpace) != -1 {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   self.current,
+				details: fmt.Sprintf("Invalid prefix %q, expected up to %d characters without spaces", raw, maxPrefixLength),
+			}
+			return
+		}
+		result.value = raw
+
+	case configureAlias:
+		fields := strings.Fields(strings.ToLower(raw))
+		if len(fields) != 2 {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   self.current,
+				details: "Expected an alias followed by a command, or by off to remove it",
+			}
+			return
+		}
+		result.value = strings.TrimPrefix(fields[0], defaultPrefix)
+		if fields[1] != "off" {
+			result.command = strings.TrimPrefix(fields[1], defaultPrefix)
+		}
+
+	case configureRole:
+		fields := strings.Fields(strings.ToLower(raw))
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "<@&") || !strings.HasSuffix(fields[0], ">") {
+			err = parserError{
+				kind:    errorInvalidSyntax,
+				token:   self.current,
+				details: "Expected a role mention followed by capabilities, or by off to revoke them",
+			}
+			return
+		}
+		result.value = strings.TrimSuffix(strings.TrimPrefix(fields[0], "<@&"), ">")
+		if len(fields) == 2 && fields[1] == "off" {
+			return
+		}
+		for _, field := range fields[1:] {
+			granted := capability(field)
+			if _, exist := capabilityDescriptions[granted]; !exist {
+				err = parserError{
+					kind:    errorInvalidSyntax,
+					token:   self.current,
+					details: fmt.Sprintf("Unknown capability %s, expected one of %s", field, capabilityNames()),
+				}
+				return
+			}
+			if !hasCapability(result.capabilities, granted) {
+				result.capabilities = append(result.capabilities, granted)
+			}
+		}
+
+	default:
+		err = parserError{
+			kind:    errorInvalidSyntax,
+			token:   self.current,
+			details: fmt.Sprintf("Unknown setting %s, expected %s, %s or %s", self.current.text, configurePrefix, configureAlias, configureRole),
+		}
+	}
 	return
 }
 
@@ -239,7 +858,7 @@ loop:
 		case next.kind == tokenSeparator || next.kind == tokenEOF:
 			end = self.previous
 			break loop
-		case next.kind == tokenIdentifier || next.kind == tokenEmote || next.kind == tokenNumber:
+		case next.kind == tokenIdentifier || next.kind == tokenEmote || next.kind == tokenNumber || next.kind == tokenTag || next.kind == tokenDoubleBang || next.kind == tokenGreater:
 			continue
 
 		default:
@@ -261,6 +880,17 @@ loop:
 	return
 }
 
+// Parses a date written on its own, such as the due date of an imported entry
+func parseDateText(text string) (result date, err parserError) {
+	p := parser{}
+	p.lexer.input = []byte(text)
+	if result, err = p.parseDate(); !err.isOK() {
+		return
+	}
+	err = p.expectNext(tokenEOF)
+	return
+}
+
 func (self *parser) parseDate() (result date, err parserError) {
 	// Needs to handle:
 	// hh:min
@@ -363,10 +993,14 @@ const (
 	tokenNumber
 	tokenEmote
 	tokenBang
+	tokenDoubleBang
 	tokenDash
 	tokenDoubleDash
 	tokenColon
 	tokenSeparator
+	tokenMention
+	tokenTag
+	tokenGreater
 
 	tokenReminder
 	tokenTask
@@ -389,10 +1023,14 @@ var tokenKindString = map[tokenKind]string{
 	tokenNumber:     "tokenNumber",
 	tokenEmote:      "tokenEmote",
 	tokenBang:       "tokenBang",
+	tokenDoubleBang: "tokenDoubleBang",
 	tokenDash:       "tokenDash",
 	tokenDoubleDash: "tokenDoubleDash",
 	tokenColon:      "tokenColon",
 	tokenSeparator:  "tokenSeparator",
+	tokenMention:    "tokenMention",
+	tokenTag:        "tokenTag",
+	tokenGreater:    "tokenGreater",
 	tokenReminder:   "tokenReminder",
 	tokenTask:       "tokenTask",
 	tokenEvery:      "tokenEvery",
@@ -443,7 +1081,12 @@ func (self *parser) scanToken() (result token, err parserError) {
 	c := self.advance()
 	switch c {
 	case '!':
-		result.kind = tokenBang
+		if !self.isEOF() && self.peek() == '!' {
+			self.advance()
+			result.kind = tokenDoubleBang
+		} else {
+			result.kind = tokenBang
+		}
 
 	case ':':
 		start := self.lexer.current
@@ -484,6 +1127,44 @@ func (self *parser) scanToken() (result token, err parserError) {
 	case ',':
 		result.kind = tokenSeparator
 
+	case '>':
+		result.kind = tokenGreater
+
+	case '#':
+		for !self.isEOF() && isTagChar(self.peek()) {
+			self.advance()
+		}
+		if self.lexer.current-result.start == 1 {
+			result.end = self.lexer.current
+			result.kind = tokenInvalid
+			result.text = "#"
+			err = parserError{
+				kind:    errorInvalidToken,
+				token:   result,
+				details: "# is not a valid tag",
+			}
+			return
+		}
+		result.kind = tokenTag
+
+	case '<':
+		// Discord mentions: <@user>, <@!user>, <@&role> and <#channel>
+		if !self.lexMention() {
+			for !self.isEOF() && self.peek() != ' ' && self.peek() != ',' {
+				self.advance()
+			}
+			result.end = self.lexer.current
+			result.kind = tokenInvalid
+			result.text = string(self.lexer.input[result.start:result.end])
+			err = parserError{
+				kind:    errorInvalidToken,
+				token:   result,
+				details: fmt.Sprintf("%s is not a valid mention", result.text),
+			}
+			return
+		}
+		result.kind = tokenMention
+
 	default:
 		switch {
 		case isLetter(c):
@@ -536,6 +1217,32 @@ func (self *parser) scanToken() (result token, err parserError) {
 	return
 }
 
+func (self *parser) lexMention() bool {
+	if self.isEOF() {
+		return false
+	}
+	switch self.advance() {
+	case '@':
+		if !self.isEOF() && (self.peek() == '!' || self.peek() == '&') {
+			self.advance()
+		}
+	case '#':
+	default:
+		return false
+	}
+
+	digits := 0
+	for !self.isEOF() && isNumber(self.peek()) {
+		self.advance()
+		digits += 1
+	}
+	if digits == 0 || self.isEOF() || self.peek() != '>' {
+		return false
+	}
+	self.advance()
+	return true
+}
+
 func (self *parser) isEOF() bool {
 	return self.lexer.current >= len(self.lexer.input)
 }
@@ -548,6 +1255,10 @@ func isNumber(c byte) bool {
 	return (c >= '0' && c <= '9')
 }
 
+func isTagChar(c byte) bool {
+	return isLetter(c) || isNumber(c) || c == '-' || c == '_'
+}
+
 func (self *parser) advance() byte {
 	self.lexer.current += 1
 	return self.lexer.input[self.lexer.current-1]
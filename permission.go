@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type capability string
+
+const (
+	capabilitySettings    capability = "settings"
+	capabilityAssign      capability = "assign"
+	capabilityAcknowledge capability = "acknowledge"
+)
+
+var capabilityDescriptions = map[capability]string{
+	capabilitySettings:    "change the settings of the server",
+	capabilityAssign:      "assign tasks to others",
+	capabilityAcknowledge: "acknowledge the notifications of others",
+}
+
+// Capabilities everyone has until they are granted to a role.
+// Acknowledging for others has to be granted
+var openCapabilities = map[capability]bool{
+	capabilitySettings: true,
+	capabilityAssign:   true,
+}
+
+type (
+	// Implemented by the commands needing a capability,
+	// which depends on their arguments
+	restrictedCommand interface {
+		// Empty when the author needs none
+		requiredCapability(authorID string) capability
+	}
+)
+
+func capabilityNames() string {
+	names := make([]string, 0, len(capabilityDescriptions))
+	for c := range capabilityDescriptions {
+		names = append(names, fmt.Sprintf("`%s`", c))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Whether one of the roles has the capability in the server
+func (g *guildSettings) allows(roles []string, c capability) bool {
+	granted := false
+	for role, capabilities := range g.roles {
+		if !hasCapability(capabilities, c) {
+			continue
+		}
+		granted = true
+		for _, r := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return !granted && openCapabilities[c]
+}
+
+func hasCapability(capabilities []capability, c capability) bool {
+	for _, granted := range capabilities {
+		if granted == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Capability the author needs for the command and lacks, empty when allowed.
+// Must run on the state goroutine
+func (a *app) missingCapability(ctx *commandContext, cmd command, authorID string) capability {
+	restricted, ok := cmd.(restrictedCommand)
+	if !ok {
+		return ""
+	}
+	required := restricted.requiredCapability(authorID)
+	if required == "" || a.guild(ctx.guildID).allows(ctx.roles, required) {
+		return ""
+	}
+	return required
+}
+
+func permissionDenied(cmd command, c capability) *commandResult {
+	return &commandResult{
+		title:       cmd.String(),
+		description: fmt.Sprintf("You need a role allowed to %s", capabilityDescriptions[c]),
+		status:      statusError,
+	}
+}
+
+func (c *configureCommand) requiredCapability(authorID string) capability {
+	if c.setting == "" {
+		return ""
+	}
+	return capabilitySettings
+}
+
+// Assigning a task to yourself needs no capability
+func (s *staffMeCommand) requiredCapability(authorID string) capability {
+	for _, target := range s.targets {
+		if target.kind != mentionUser || target.id != authorID {
+			return capabilityAssign
+		}
+	}
+	return ""
+}
+
+func (r *reassignMeCommand) requiredCapability(authorID string) capability {
+	for _, target := range r.targets {
+		if target.kind != mentionUser || target.id != authorID {
+			return capabilityAssign
+		}
+	}
+	return ""
+}
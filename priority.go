@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// 1 is the most urgent, 0 means unset and ranks as 4
+	priority int
+
+	sortOrder int
+)
+
+const (
+	priorityNone priority = iota
+	priority1
+	priority2
+	priority3
+	priority4
+)
+
+const (
+	sortSmart sortOrder = iota
+	sortDue
+	sortPriority
+	sortAdded
+)
+
+var priorityWords = map[string]priority{
+	"!!": priority1,
+	"p1": priority1,
+	"p2": priority2,
+	"p3": priority3,
+	"p4": priority4,
+}
+
+var sortOrders = map[string]sortOrder{
+	"smart":    sortSmart,
+	"due":      sortDue,
+	"priority": sortPriority,
+	"added":    sortAdded,
+}
+
+var sortOrderString = map[sortOrder]string{
+	sortSmart:    "smart",
+	sortDue:      "due",
+	sortPriority: "priority",
+	sortAdded:    "added",
+}
+
+func sortOrderNames() string {
+	names := make([]string, 0, len(sortOrders))
+	for order := sortSmart; order <= sortAdded; order += 1 {
+		names = append(names, sortOrderString[order])
+	}
+	return strings.Join(names, ", ")
+}
+
+func (p priority) rank() int {
+	if p == priorityNone {
+		return int(priority4)
+	}
+	return int(p)
+}
+
+func (p priority) String() string {
+	if p == priorityNone {
+		return ""
+	}
+	return fmt.Sprintf("p%d", p)
+}
+
+func (it *item) isOverdue(now time.Time) bool {
+	return it.hasDueDate && !it.done && it.dueTime.Before(now)
+}
+
+// Sorts the items in place.
+// smart: overdue first, then by priority, then by due date
+// due: by due date, items without one last, then by priority
+// priority: by priority, then by due date
+// added: in the order they were added
+func sortItems(items []item, order sortOrder, now time.Time) {
+	byDue := func(a, b *item) (less, decided bool) {
+		switch {
+		case a.hasDueDate && b.hasDueDate && !a.dueTime.Equal(b.dueTime):
+			return a.dueTime.Before(b.dueTime), true
+		case a.hasDueDate != b.hasDueDate:
+			return a.hasDueDate, true
+		}
+		return false, false
+	}
+	byPriority := func(a, b *item) (less, decided bool) {
+		if a.priority.rank() != b.priority.rank() {
+			return a.priority.rank() < b.priority.rank(), true
+		}
+		return false, false
+	}
+
+	var criteria []func(a, b *item) (bool, bool)
+	switch order {
+	case sortSmart:
+		byOverdue := func(a, b *item) (less, decided bool) {
+			if a.isOverdue(now) != b.isOverdue(now) {
+				return a.isOverdue(now), true
+			}
+			return false, false
+		}
+		criteria = append(criteria, byOverdue, byPriority, byDue)
+	case sortDue:
+		criteria = append(criteria, byDue, byPriority)
+	case sortPriority:
+		criteria = append(criteria, byPriority, byDue)
+	case sortAdded:
+		return
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, criterion := range criteria {
+			if less, decided := criterion(&items[i], &items[j]); decided {
+				return less
+			}
+		}
+		return false
+	})
+}
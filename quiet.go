@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const heldAlarms = "Held Notifications"
+
+type (
+	// Daily window without alarms, in minutes of the day of the user.
+	// The window wraps around midnight when it ends before it starts
+	quietHours struct {
+		start int
+		end   int
+	}
+
+	// Alarm kept for the end of the quiet hours or do not disturb
+	heldAlarm struct {
+		itemID      int
+		description string
+	}
+)
+
+func (q quietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.start/60, q.start%60, q.end/60, q.end%60)
+}
+
+func parseQuietHours(text string) (q *quietHours, err error) {
+	var startHour, startMin, endHour, endMin int
+	_, err = fmt.Sscanf(text, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin)
+	if err != nil {
+		return nil, err
+	}
+	return &quietHours{start: startHour*60 + startMin, end: endHour*60 + endMin}, nil
+}
+
+func (q quietHours) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if q.start <= q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+// Time zone of the user, the one of the bot when unset
+func (u *user) location() *time.Location {
+	if u.timeZone == nil {
+		return time.Local
+	}
+	return u.timeZone
+}
+
+// Urgent items (p1) always ring
+func (u *user) holds(reminder *item, now time.Time) bool {
+	return reminder.priority != priority1 && u.isQuiet(now)
+}
+
+func (u *user) isQuiet(now time.Time) bool {
+	if now.Before(u.dndUntil) {
+		return true
+	}
+	return u.quiet != nil && u.quiet.contains(now.In(u.location()))
+}
+
+// Holds the alarm while the user is quiet, only the last alarm
+// of a reminder is kept.
+// Must run on the state goroutine
+func (a *app) holdAlarm(u *user, reminder *item, description string, now time.Time) bool {
+	if !u.holds(reminder, now) {
+		return false
+	}
+	a.saveHeldAlarm(u, reminder.id, description)
+	for i := range u.held {
+		if u.held[i].itemID == reminder.id {
+			u.held[i].description = description
+			return true
+		}
+	}
+	u.held = append(u.held, heldAlarm{itemID: reminder.id, description: description})
+	return true
+}
+
+// Delivers the held alarms in one message once the user is no longer quiet.
+// Must run on the state goroutine
+func (a *app) releaseAlarms(u *user, now time.Time) {
+	if len(u.held) == 0 || u.isQuiet(now) {
+		return
+	}
+	// Reminders acknowledged or removed meanwhile are not due anymore
+	var descriptions []string
+	var itemIDs []int
+	for _, held := range u.held {
+		if findItemByID(u.reminders, held.itemID) != -1 {
+			descriptions = append(descriptions, strings.ReplaceAll(held.description, "**", ""))
+			itemIDs = append(itemIDs, held.itemID)
+		}
+	}
+	if len(descriptions) == 0 {
+		a.clearHeldAlarms(u)
+		return
+	}
+
+	// ☑ acknowledges every reminder of the batch
+	result := &commandResult{title: heldAlarms}
+	result.addSection("While you were away", iconReminders, bulletItems(descriptions), "")
+	err := a.enqueue(
+		textMessage(a.remindChannelID, fmt.Sprintf("<@%s>", u.id)),
+		resultMessage(a.remindChannelID, result, "☑").about(itemIDs...),
+	)
+	if err == nil {
+		a.clearHeldAlarms(u)
+	}
+}
+
+func (a *app) initHeldAlarms() error {
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS held_alarms (
+		item_id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		description TEXT NOT NULL
+	);`)
+}
+
+// Must be called once the users are loaded
+func (a *app) loadHeldAlarms() error {
+	byID := make(map[int]*user, len(a.users))
+	for _, u := range a.users {
+		byID[u.uniqueID] = u
+	}
+	results, err := a.db.Query("SELECT item_id, user_id, description FROM held_alarms;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	return results.Iterate(func(d types.Document) error {
+		var held heldAlarm
+		var userID int
+		err := document.Scan(d, &held.itemID, &userID, &held.description)
+		if u, ok := byID[userID]; ok {
+			u.held = append(u.held, held)
+		}
+		return err
+	})
+}
+
+// Must run on the state goroutine
+func (a *app) saveHeldAlarm(u *user, itemID int, description string) {
+	err := a.db.Update(func(tx *genji.Tx) error {
+		err := tx.Exec("DELETE FROM held_alarms WHERE item_id = ?;", itemID)
+		if err != nil {
+			return err
+		}
+		return tx.Exec(
+			"INSERT INTO held_alarms (item_id, user_id, description) VALUES (?, ?, ?);",
+			itemID,
+			u.uniqueID,
+			description,
+		)
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Must run on the state goroutine
+func (a *app) clearHeldAlarms(u *user) {
+	u.held = nil
+	if err := a.db.Exec("DELETE FROM held_alarms WHERE user_id = ?;", u.uniqueID); err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Must run on the state goroutine
+func (a *app) saveQuietSettings(u *user) {
+	var quiet, zone string
+	if u.quiet != nil {
+		quiet = u.quiet.String()
+	}
+	if u.timeZone != nil {
+		zone = u.timeZone.String()
+	}
+	var dndUntil string
+	if !u.dndUntil.IsZero() {
+		dndUntil = u.dndUntil.Format(timeFormat)
+	}
+	err := a.db.Exec(
+		"UPDATE users SET quiet_hours = ?, time_zone = ?, dnd_until = ? WHERE id = ?;",
+		quiet,
+		zone,
+		dndUntil,
+		u.uniqueID,
+	)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+}
+
+// Restores the settings stored by saveQuietSettings, invalid ones are dropped
+func (u *user) loadQuietSettings(quiet, zone, dndUntil string) {
+	var err error
+	if quiet != "" {
+		if u.quiet, err = parseQuietHours(quiet); err != nil {
+			log.Printf("Invalid quiet hours %q for %s: %s", quiet, u.id, err)
+		}
+	}
+	if zone != "" {
+		if u.timeZone, err = time.LoadLocation(zone); err != nil {
+			log.Printf("Invalid time zone %q for %s: %s", zone, u.id, err)
+		}
+	}
+	if dndUntil != "" {
+		if u.dndUntil, err = time.Parse(timeFormat, dndUntil); err != nil {
+			log.Printf("Invalid do not disturb end %q for %s: %s", dndUntil, u.id, err)
+		}
+	}
+}
+
+func quietDescription(u *user) string {
+	if u.quiet == nil {
+		return "You have no quiet hours"
+	}
+	return fmt.Sprintf(
+		"Your quiet hours are from %s (%s)",
+		strings.Replace(u.quiet.String(), "-", " to ", 1),
+		u.location(),
+	)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Declaration of a command, parseCommand and !helpme
+	// are generated from the registered ones
+	commandSpec struct {
+		name    string
+		aliases []string
+		// Comma separated arguments, the usage is written from them
+		args        []commandArg
+		description string
+		examples    []string
+		parse       func(p *parser) (command, parserError)
+	}
+
+	commandArg struct {
+		// Alternative forms of the argument, such as `2h` or `off`
+		forms    []string
+		optional bool
+		// Written after the forms
+		note string
+	}
+
+	commandRegistry struct {
+		specs  []*commandSpec
+		byName map[string]*commandSpec
+	}
+)
+
+// Commands in the order !helpme lists them
+var commands commandRegistry
+
+// Options shared by the commands adding a reminder
+var reminderOptions = []commandArg{
+	optionalArg("list: name"),
+	optionalArg("nag: 10m 30m 2h"),
+	optionalArg("max: 5"),
+	optionalArg("escalate: dm", "escalate: mention after 3"),
+}
+
+func (r *commandRegistry) register(spec *commandSpec) {
+	if r.byName == nil {
+		r.byName = make(map[string]*commandSpec)
+	}
+	for _, name := range append([]string{spec.name}, spec.aliases...) {
+		if _, exist := r.byName[name]; exist {
+			panic(fmt.Sprintf("command %s is registered twice", name))
+		}
+		r.byName[name] = spec
+	}
+	r.specs = append(r.specs, spec)
+}
+
+// Finds a command by its name or one of its aliases
+func (r *commandRegistry) lookup(name string) (*commandSpec, bool) {
+	spec, exist := r.byName[name]
+	return spec, exist
+}
+
+func arg(forms ...string) commandArg {
+	return commandArg{forms: forms}
+}
+
+func optionalArg(forms ...string) commandArg {
+	return commandArg{forms: forms, optional: true}
+}
+
+func (c commandArg) withNote(note string) commandArg {
+	c.note = note
+	return c
+}
+
+func (c commandArg) String() string {
+	forms := make([]string, len(c.forms))
+	for i, form := range c.forms {
+		forms[i] = fmt.Sprintf("`%s`", form)
+	}
+	text := strings.Join(forms, " or ")
+	if c.optional {
+		text = "(optional)" + text
+	}
+	if c.note != "" {
+		text += " " + c.note
+	}
+	return text
+}
+
+func (s *commandSpec) usage() string {
+	if len(s.args) == 0 {
+		return "No required arguments"
+	}
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = arg.String()
+	}
+	return strings.Join(args, ", ")
+}
+
+func (s *commandSpec) summary() string {
+	return fmt.Sprintf("%s.\n%s", s.usage(), s.description)
+}
+
+// Detail view of !helpme <command>
+func (s *commandSpec) help(title string) *commandResult {
+	result := &commandResult{
+		title:       title,
+		description: fmt.Sprintf("`!%s` %s", s.name, s.usage()),
+	}
+	result.addTextSection("Description", s.description)
+	if len(s.aliases) > 0 {
+		aliases := make([]string, len(s.aliases))
+		for i, alias := range s.aliases {
+			aliases[i] = fmt.Sprintf("`!%s`", alias)
+		}
+		result.addTextSection("Aliases", strings.Join(aliases, ", "))
+	}
+	if len(s.examples) > 0 {
+		examples := make([]string, len(s.examples))
+		for i, example := range s.examples {
+			examples[i] = fmt.Sprintf("`%s`", example)
+		}
+		result.addTextSection("Examples", strings.Join(examples, "\n"))
+	}
+	return result
+}
+
+func init() {
+	commands.register(&commandSpec{
+		name:        "briefme",
+		args:        []commandArg{optionalArg("list: name"), optionalArg("#tags")},
+		description: "Display the active reminders and tasks of the user grouped by list, only those of the list and with the tags when given",
+		examples:    []string{"!briefme", "!briefme list: release-1.2", "!briefme #backend"},
+		parse:       func(p *parser) (command, parserError) { return p.parseBriefMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name: "remindme",
+		args: append(
+			[]commandArg{optionalArg("mentions"), arg("name of the reminder #tags p1-p4"), arg("date")},
+			reminderOptions...,
+		),
+		description: "Add a reminder for the user, or for the mentioned users, roles and channels. Once overdue it is nagged after each interval, the last one repeating, escalated after some nags and marked missed after the maximum",
+		examples: []string{
+			"!remindme pick up the milk, 18:30",
+			"!remindme <@&4242>, renew the certificate p1, 01-03-30 9:00, nag: 10m 1h, escalate: dm after 2",
+		},
+		parse: func(p *parser) (command, parserError) { return p.parseRemindMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "remindus",
+		args:        append([]commandArg{arg("mentions"), arg("name of the reminder"), arg("date")}, reminderOptions...),
+		description: "Add a reminder shared by the user and the mentioned users, roles and channels. Each of them acknowledges it separately",
+		examples:    []string{"!remindus <@1234> <@5678>, sprint review, 14-03-30 15:00"},
+		parse:       func(p *parser) (command, parserError) { return p.parseRemindUsCmd() },
+	})
+	commands.register(&commandSpec{
+		name: "staffme",
+		args: []commandArg{optionalArg("mention"), arg("name of the task #tags p1-p4"), optionalArg("date"), optionalArg("list: name")},
+		description: fmt.Sprintf(
+			"Add a task for the user, a subtask with `task > subtask`, or assign it to the mentioned user who accepts it with %s or declines it with %s",
+			acceptEmoji,
+			declineEmoji,
+		),
+		examples: []string{
+			"!staffme write tests #backend, list: release-1.2",
+			"!staffme write tests > parser",
+			"!staffme <@1234>, review the docs p2, 20-03-30",
+		},
+		parse: func(p *parser) (command, parserError) { return p.parseStaffMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "doneme",
+		args:        []commandArg{arg("name of the task")},
+		description: "Mark a task, or a subtask written `task > subtask`, as done. A task is done once all its subtasks are",
+		examples:    []string{"!doneme write tests", "!doneme write tests > parser"},
+		parse:       func(p *parser) (command, parserError) { return p.parseDoneMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "reassignme",
+		args:        []commandArg{arg("mention"), arg("name of the task")},
+		description: "Hand one of your tasks, or a task you assigned, over to the mentioned user",
+		examples:    []string{"!reassignme <@5678>, review the docs"},
+		parse:       func(p *parser) (command, parserError) { return p.parseReassignMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "briefteam",
+		description: "Display the open tasks of every user",
+		examples:    []string{"!briefteam"},
+		parse:       func(p *parser) (command, parserError) { return p.parseBriefTeamCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "sortme",
+		args:        []commandArg{arg("order").withNote("one of " + sortOrderNames())},
+		description: "Choose how the briefings of the user are sorted. `smart` lists the overdue items first, then sorts by priority and due date",
+		examples:    []string{"!sortme smart"},
+		parse:       func(p *parser) (command, parserError) { return p.parseSortMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "digestme",
+		args:        []commandArg{arg("h:min", "off"), optionalArg("week days"), optionalArg("channel mention")},
+		description: "Send a digest of today's reminders, the tasks due this week and the overdue tasks at that time, by direct message unless a channel is given",
+		examples:    []string{"!digestme 8:30", "!digestme 9:00 monday, <#4321>", "!digestme off"},
+		parse:       func(p *parser) (command, parserError) { return p.parseDigestMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "quietme",
+		args:        []commandArg{arg("h:min-h:min", "off"), optionalArg("time zone").withNote("such as `Europe/Paris`")},
+		description: "Hold the alarms during these hours and deliver them at once when they end. Urgent (p1) items still ring",
+		examples:    []string{"!quietme 22:00-7:30, Europe/Paris", "!quietme off"},
+		parse:       func(p *parser) (command, parserError) { return p.parseQuietMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "dnd",
+		args:        []commandArg{arg("duration", "off").withNote("such as `2h` or `30m`")},
+		description: "Hold the alarms for a while, like quiet hours",
+		examples:    []string{"!dnd 2h", "!dnd off"},
+		parse:       func(p *parser) (command, parserError) { return p.parseDndCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "removeme",
+		args:        []commandArg{arg("type of the item"), arg("name of the item")},
+		description: "Remove either a task, a subtask written `task > subtask`, or a reminder for the user",
+		examples:    []string{"!removeme reminder, pick up the milk", "!removeme task, write tests > parser"},
+		parse:       func(p *parser) (command, parserError) { return p.parseRemoveMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "importme",
+		description: "Import for the user the reminders and tasks of the `.ics`, `.json` or `.csv` files attached to the message",
+		examples:    []string{"!importme"},
+		parse:       func(p *parser) (command, parserError) { return p.parseImportMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "undome",
+		description: "Revert your last change to a reminder or a task: bring back what you removed, acknowledged or marked done, or remove what you added. Each use goes one change further back. Refused when someone else changed the item since",
+		examples:    []string{"!undome"},
+		parse:       func(p *parser) (command, parserError) { return p.parseUndoMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "historyme",
+		args:        []commandArg{arg("name of the item")},
+		description: "Display who created, changed, alarmed, acknowledged, completed or removed the item and when, removed items included. Subtasks are written `task > subtask`",
+		examples:    []string{"!historyme pick up the milk", "!historyme write tests > parser"},
+		parse:       func(p *parser) (command, parserError) { return p.parseHistoryMeCmd() },
+	})
+	commands.register(&commandSpec{
+		name: "configure",
+		args: []commandArg{optionalArg(
+			"prefix value",
+			"alias name command",
+			"alias name off",
+			"role mention capabilities",
+			"role mention off",
+		)},
+		description: fmt.Sprintf("Display or change the settings of the server: the prefix of the commands, such as `?` or a mention of the bot, aliases such as `!r` for `!remindme`, and the capabilities of the roles among %s. Changing the settings and assigning tasks are open to everyone until granted to a role. Command names are case-insensitive", capabilityNames()),
+		examples: []string{
+			"!configure",
+			"!configure prefix ?",
+			"!configure alias r remindme",
+			"!configure alias r off",
+			"!configure role <@&4242> settings assign acknowledge",
+		},
+		parse: func(p *parser) (command, parserError) { return p.parseConfigureCmd() },
+	})
+	commands.register(&commandSpec{
+		name:        "helpme",
+		aliases:     []string{"help"},
+		args:        []commandArg{optionalArg("command")},
+		description: "Display the commands and how to use the bot, or the details and examples of the command",
+		examples:    []string{"!helpme", "!helpme remindme"},
+		parse:       func(p *parser) (command, parserError) { return p.parseHelpMeCmd() },
+	})
+}
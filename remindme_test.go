@@ -1,13 +1,15 @@
 package main
 
 import (
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestLexer(t *testing.T) {
 	inputs := []string{
-		"12", "remind", ":myemote:", "!", "-", "--", ":", "|",
+		"12", "remind", ":myemote:", "!", "-", "--", ":", ",",
 		"reminder", "task", "today", "tomorrow",
 	}
 
@@ -111,12 +113,13 @@ func TestParseDate(t *testing.T) {
 		"10-07-22 12:30",
 	}
 
+	// Without a year, the date is in the current one
 	y, m, d := time.Now().Date()
 	expects := []date{
-		{day: 10, month: time.July, year: 2022, hour: 0, min: 0},
+		{day: 10, month: time.July, year: y, hour: 0, min: 0},
 		{day: 10, month: time.July, year: 2022, hour: 0, min: 0},
 		{day: d, month: m, year: y, hour: 12, min: 30},
-		{day: 10, month: time.July, year: 2022, hour: 12, min: 30},
+		{day: 10, month: time.July, year: y, hour: 12, min: 30},
 		{day: 10, month: time.July, year: 2022, hour: 12, min: 30},
 	}
 
@@ -139,29 +142,29 @@ func TestParseDate(t *testing.T) {
 		}
 		if d.month != expect.month {
 			t.Errorf(
-				"invalid day, expected %s got %s",
+				"invalid month, expected %s got %s",
 				expect.month.String(),
 				d.month.String(),
 			)
 		}
 		if d.year != expect.year {
 			t.Errorf(
-				"invalid day, expected %d got %d",
-				expect.day,
-				d.day,
+				"invalid year, expected %d got %d",
+				expect.year,
+				d.year,
 			)
 		}
 
 		if d.hour != expect.hour {
 			t.Errorf(
-				"invalid day, expected %d got %d",
+				"invalid hour, expected %d got %d",
 				expect.hour,
 				d.hour,
 			)
 		}
 		if d.min != expect.min {
 			t.Errorf(
-				"invalid day, expected %d got %d",
+				"invalid minute, expected %d got %d",
 				expect.min,
 				d.min,
 			)
@@ -170,11 +173,10 @@ func TestParseDate(t *testing.T) {
 }
 
 func TestParseRemindMeCmd(t *testing.T) {
-	input := "!remindme pick up the milk | 18:30"
+	input := "!remindme pick up the milk, 18:30"
 
 	y, m, d := time.Now().Date()
 	expect := remindMeCommand{
-		kind:       commandRemindMe,
 		identifier: "pick up the milk",
 		date:       date{day: d, month: m, year: y, hour: 18, min: 30},
 	}
@@ -185,14 +187,6 @@ func TestParseRemindMeCmd(t *testing.T) {
 	}
 	switch r := result.(type) {
 	case *remindMeCommand:
-		if r.kind != expect.kind {
-			t.Errorf(
-				"invalid kind, expected %d got %d",
-				expect.kind,
-				r.kind,
-			)
-		}
-
 		if r.identifier != expect.identifier {
 			t.Errorf(
 				"invalid identifier, expected %s got %s",
@@ -208,25 +202,25 @@ func TestParseRemindMeCmd(t *testing.T) {
 				r.date,
 			)
 		}
+	default:
+		t.Errorf("invalid command, got %#v", result)
 	}
 }
 
 func TestParseStaffMeCmd(t *testing.T) {
 	inputs := []string{
-		"!staffme finish writing unit tests | 18:30",
+		"!staffme finish writing unit tests, 18:30",
 		"!staffme finish writing unit tests",
 	}
 
 	y, m, d := time.Now().Date()
 	expects := []staffMeCommand{
 		{
-			kind:       commandStaffMe,
 			identifier: "finish writing unit tests",
 			hasDueDate: true,
 			date:       date{day: d, month: m, year: y, hour: 18, min: 30},
 		},
 		{
-			kind:       commandStaffMe,
 			identifier: "finish writing unit tests",
 			hasDueDate: false,
 		},
@@ -242,14 +236,6 @@ func TestParseStaffMeCmd(t *testing.T) {
 		expect := expects[i]
 		switch r := result.(type) {
 		case *staffMeCommand:
-			if r.kind != expect.kind {
-				t.Errorf(
-					"invalid kind, expected %d got %d",
-					expect.kind,
-					r.kind,
-				)
-			}
-
 			if r.identifier != expect.identifier {
 				t.Errorf(
 					"invalid identifier, expected %s got %s",
@@ -258,7 +244,7 @@ func TestParseStaffMeCmd(t *testing.T) {
 				)
 			}
 
-			if r.hasDueDate != r.hasDueDate {
+			if r.hasDueDate != expect.hasDueDate {
 				t.Errorf(
 					"invalid due date flag, expected %t got %t",
 					expect.hasDueDate,
@@ -272,24 +258,24 @@ func TestParseStaffMeCmd(t *testing.T) {
 					r.date,
 				)
 			}
+		default:
+			t.Errorf("invalid command, got %#v", result)
 		}
 	}
 }
 
 func TestParseRemoveMeCmd(t *testing.T) {
 	inputs := []string{
-		"!removeme task | writing unit tests",
-		"!removeme reminder | writing unit tests",
+		"!removeme task, writing unit tests",
+		"!removeme reminder, writing unit tests",
 	}
 
 	expects := []removeMeCommand{
 		{
-			kind:       commandRemoveMe,
 			list:       token{kind: tokenTask},
 			identifier: "writing unit tests",
 		},
 		{
-			kind:       commandRemoveMe,
 			list:       token{kind: tokenReminder},
 			identifier: "writing unit tests",
 		},
@@ -305,14 +291,6 @@ func TestParseRemoveMeCmd(t *testing.T) {
 		expect := expects[i]
 		switch r := result.(type) {
 		case *removeMeCommand:
-			if r.kind != expect.kind {
-				t.Errorf(
-					"invalid kind, expected %d got %d",
-					expect.kind,
-					r.kind,
-				)
-			}
-
 			if r.list.kind != expect.list.kind {
 				t.Errorf(
 					"invalid keyword, expected %s got %s",
@@ -328,6 +306,275 @@ func TestParseRemoveMeCmd(t *testing.T) {
 					r.identifier,
 				)
 			}
+		default:
+			t.Errorf("invalid command, got %#v", result)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	inputs := []string{
+		"!remindme <@!12> <@&34>, standup, 10-01-30 11:00",
+		"!remindus <#56>, standup, 10-01-30 11:00",
+		"!remindus standup, 10-01-30 11:00",
+		"!remindme <@x>, standup, 10-01-30 11:00",
+	}
+	expects := [][]mention{
+		{{kind: mentionUser, id: "12"}, {kind: mentionRole, id: "34"}},
+		{{kind: mentionChannel, id: "56"}},
+		nil,
+		nil,
+	}
+
+	for i, input := range inputs {
+		t.Logf("input %d", i)
+		cmd, err := parseCommand(input)
+
+		var targets []mention
+		switch c := cmd.(type) {
+		case *remindMeCommand:
+			targets = c.targets
+		case *remindUsCommand:
+			targets = c.targets
+		}
+		if expects[i] == nil {
+			if err.isOK() {
+				t.Errorf("expected an error, got %#v", cmd)
+			}
+			continue
+		}
+		if !err.isOK() {
+			t.Errorf("parsing error: %s", err.details)
+			continue
+		}
+		if len(targets) != len(expects[i]) {
+			t.Errorf("invalid targets, expected %v got %v", expects[i], targets)
+			continue
+		}
+		for j := range targets {
+			if targets[j] != expects[i][j] {
+				t.Errorf("invalid target, expected %v got %v", expects[i][j], targets[j])
+			}
+		}
+	}
+}
+
+func TestParseImportEntries(t *testing.T) {
+	inputs := []importFile{
+		{name: "a.ics", data: []byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:pick up\r\n  the milk\\, eggs\r\nDTSTART:20220620T173000\r\nEND:VEVENT\r\nBEGIN:VTODO\r\nSUMMARY:write tests #backend\r\nEND:VTODO\r\nEND:VCALENDAR\r\n")},
+		{name: "a.csv", data: []byte("kind,name,due\nreminder,\"pick up the milk, eggs\",20-06-22 17:30\ntask,write tests #backend\n")},
+		{name: "a.json", data: []byte(`[{"kind": "reminder", "name": "pick up the milk, eggs", "due": "20-06-22 17:30"}, {"kind": "task", "name": "write tests #backend"}]`)},
+		// Properties of the nested alarms are not the ones of their entry
+		{name: "b.ics", data: []byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:pick up the milk\\, eggs\r\nBEGIN:VALARM\r\nACTION:DISPLAY\r\nSUMMARY:ring\r\nTRIGGER:-PT15M\r\nEND:VALARM\r\nDTSTART:20220620T173000\r\nEND:VEVENT\r\nBEGIN:VTODO\r\nSUMMARY:write tests #backend\r\nBEGIN:VALARM\r\nACTION:DISPLAY\r\nSUMMARY:due soon\r\nTRIGGER:-PT1H\r\nEND:VALARM\r\nEND:VTODO\r\nEND:VCALENDAR\r\n")},
+	}
+
+	for i, input := range inputs {
+		t.Logf("input %d", i)
+		entries, err := parseImportFile(input)
+		if err != nil {
+			t.Errorf("parsing error: %s", err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("invalid number of entries, expected %d got %d", 2, len(entries))
+			continue
+		}
+		if entries[0].Due != "20-06-22 17:30" {
+			t.Errorf("invalid due date, expected 20-06-22 17:30 got %q", entries[0].Due)
+		}
+
+		cmd, err := entries[0].command()
+		if reminder, ok := cmd.(*remindMeCommand); err != nil || !ok || reminder.identifier != "pick up the milk, eggs" {
+			t.Errorf("invalid reminder command, got %#v %v", cmd, err)
+		}
+		cmd, err = entries[1].command()
+		if task, ok := cmd.(*staffMeCommand); err != nil || !ok || task.identifier != "write tests" || task.hasDueDate || len(task.tags) != 1 {
+			t.Errorf("invalid task command, got %#v %v", cmd, err)
+		}
+	}
+
+	invalids := []importEntry{
+		{Kind: "reminder", Name: "pick up the milk"},
+		{Kind: "reminder", Name: "pick up the milk", Due: "tomorrow"},
+		{Kind: "event", Name: "pick up the milk"},
+		{Kind: "task", Name: " "},
+	}
+	for _, entry := range invalids {
+		if _, err := entry.command(); err == nil {
+			t.Errorf("expected an error for %#v", entry)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	payload := []byte(`{"event":"task_done"}`)
+	expect := "92b57886059c801c8a7d85651fcaa4458d48534ae20d6ffcaf287817bcd1f62f"
+
+	result := signWebhookPayload("secret", payload)
+	if result != expect {
+		t.Errorf(
+			"invalid signature, expected %s got %s",
+			expect,
+			result,
+		)
+	}
+
+	backoffs := []time.Duration{
+		webhookBaseBackoff, 2 * webhookBaseBackoff, 4 * webhookBaseBackoff,
+	}
+	for i, expect := range backoffs {
+		if result := webhookBackoff(i + 1); result != expect {
+			t.Errorf(
+				"invalid backoff for attempt %d, expected %s got %s",
+				i+1,
+				expect,
+				result,
+			)
+		}
+	}
+	if result := webhookBackoff(webhookMaxAttempts * 10); result != webhookMaxBackoff {
+		t.Errorf(
+			"invalid max backoff, expected %s got %s",
+			webhookMaxBackoff,
+			result,
+		)
+	}
+}
+
+func TestRenderResult(t *testing.T) {
+	result := &commandResult{title: "Brief me!"}
+	result.addSection("Reminders", iconReminders, []resultItem{
+		{mark: markBullet, name: "pick up the milk", detail: "18:30"},
+	}, "No active reminders")
+	result.addSection("Tasks", iconTasks, nil, "No active tasks")
+
+	expects := map[renderFormat]string{
+		formatText:     "== Brief me! ==\nReminders:\n  - pick up the milk (18:30)\nTasks:\n  No active tasks\n",
+		formatMarkdown: "## Brief me!\n\n### Reminders\n\n- **pick up the milk** — 18:30\n\n### Tasks\n\nNo active tasks\n\n",
+		formatJSON:     `{"title":"Brief me!","status":"ok","sections":[{"name":"Reminders","items":[{"mark":"bullet","name":"pick up the milk","detail":"18:30"}]},{"name":"Tasks","text":"No active tasks"}]}` + "\n",
+	}
+	for format, expect := range expects {
+		if r := render(result, format); r != expect {
+			t.Errorf(
+				"invalid rendering, expected %q got %q",
+				expect,
+				r,
+			)
+		}
+	}
+
+	embed := renderEmbeds(result)[0]
+	if len(embed.Fields) != 2 {
+		t.Fatalf(
+			"invalid number of fields, expected %d got %d",
+			2,
+			len(embed.Fields),
+		)
+	}
+	expect := ":small_orange_diamond: **pick up the milk**  ||  18:30\n"
+	if embed.Fields[0].Value != expect {
+		t.Errorf(
+			"invalid field, expected %q got %q",
+			expect,
+			embed.Fields[0].Value,
+		)
+	}
+	// Discord rejects empty field values
+	empty := &commandResult{title: "Import me!"}
+	empty.addSection("Skipped", iconNone, nil, "")
+	if value := renderEmbeds(empty)[0].Fields[0].Value; value != emptyFieldValue {
+		t.Errorf("invalid empty field, expected %q got %q", emptyFieldValue, value)
+	}
+}
+
+func TestDigestTimeZone(t *testing.T) {
+	u := &user{timeZone: time.FixedZone("UTC+10", 10*60*60)}
+	u.reminders = []item{
+		{id: 1, name: "standup", kind: itemReminder, hasDueDate: true, dueTime: time.Date(2030, time.January, 10, 20, 0, 0, 0, time.UTC)},
+		{id: 2, name: "dinner", kind: itemReminder, hasDueDate: true, dueTime: time.Date(2030, time.January, 10, 10, 0, 0, 0, time.UTC)},
+	}
+
+	// Already the 11th for the user
+	result := digestResult(u, time.Date(2030, time.January, 10, 23, 30, 0, 0, time.UTC))
+	if result.description != "Friday 11 January" {
+		t.Errorf("invalid digest day, expected Friday 11 January got %s", result.description)
+	}
+	if today := result.sections[0].items; len(today) != 1 || today[0].name != "standup" {
+		t.Errorf("invalid reminders of the day, got %v", today)
+	}
+}
+
+func TestTerminalText(t *testing.T) {
+	out := &strings.Builder{}
+	m := &terminalMessenger{out: out, format: formatJSON}
+	if _, err := m.sendText(replChannelID, "<@100> \"standup\" \x01 \u2028"); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"text":"\u003c@100\u003e \"standup\" \u0001 \u2028"}` + "\n"
+	if out.String() != expect {
+		t.Errorf("invalid text, expected %q got %q", expect, out.String())
+	}
+}
+
+func TestTruncateMarkdown(t *testing.T) {
+	inputs := []struct {
+		text  string
+		limit int
+	}{
+		{"short", 10},
+		{"**bold** text", 10},
+		{"see <@123456789> now", 12},
+		{"party :tada: time", 10},
+		{"éééééééééé", 5},
+		{"||secret **loud** things||", 17},
+	}
+	expects := []string{
+		"short",
+		"**bold** …",
+		"see …",
+		"party …",
+		"éééé…",
+		"||secret **l…**||",
+	}
+	for i, input := range inputs {
+		result := truncateMarkdown(input.text, input.limit)
+		if result != expects[i] {
+			t.Errorf("invalid truncation of %q, expected %q got %q", input.text, expects[i], result)
+		}
+		if n := utf8.RuneCountInString(result); n > input.limit {
+			t.Errorf("truncation of %q over the limit with %d characters", input.text, n)
+		}
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	for _, spec := range commands.specs {
+		for _, example := range spec.examples {
+			cmd, err := parseCommand(example)
+			if !err.isOK() || cmd == nil {
+				t.Errorf("example %q of !%s does not parse: %s", example, spec.name, err.details)
+			}
+		}
+		for _, alias := range spec.aliases {
+			if found, _ := commands.lookup(alias); found != spec {
+				t.Errorf("alias !%s does not lead to !%s", alias, spec.name)
+			}
 		}
 	}
+
+	spec, _ := commands.lookup("dnd")
+	if usage, expect := spec.usage(), "`duration` or `off` such as `2h` or `30m`"; usage != expect {
+		t.Errorf("invalid usage, expected %q got %q", expect, usage)
+	}
+	spec, _ = commands.lookup("undome")
+	if usage, expect := spec.usage(), "No required arguments"; usage != expect {
+		t.Errorf("invalid usage, expected %q got %q", expect, usage)
+	}
+
+	cmd, err := parseCommand("!help !dnd")
+	if help, ok := cmd.(*helpMeCommand); !err.isOK() || !ok || help.topic != "dnd" {
+		t.Errorf("invalid help command, got %#v", cmd)
+	}
+	if _, err := parseCommand("!unknownme"); err.kind != errorUnknownCommand {
+		t.Errorf("expected an unknown command error, got %#v", err)
+	}
 }
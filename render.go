@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	errorColor = 0xe74c3c
+)
+
+type (
+	// Transport neutral output of a command.
+	// Renderers turn it into the format of the frontend
+	commandResult struct {
+		title       string
+		description string
+		status      resultStatus
+		sections    []resultSection
+	}
+
+	// A section either holds free text or a list of items
+	resultSection struct {
+		name  string
+		icon  resultIcon
+		text  string
+		items []resultItem
+	}
+
+	resultItem struct {
+		mark      resultMark
+		name      string
+		detail    string
+		highlight bool
+	}
+
+	resultStatus int
+	resultIcon   int
+	resultMark   int
+
+	renderFormat int
+)
+
+const (
+	statusOK resultStatus = iota
+	statusError
+)
+
+const (
+	iconNone resultIcon = iota
+	iconReminders
+	iconTasks
+)
+
+const (
+	markBullet resultMark = iota
+	markTodo
+	markDone
+)
+
+const (
+	formatText renderFormat = iota
+	formatJSON
+	formatMarkdown
+)
+
+var renderFormats = map[string]renderFormat{
+	"text":     formatText,
+	"json":     formatJSON,
+	"markdown": formatMarkdown,
+}
+
+var resultStatusString = map[resultStatus]string{
+	statusOK:    "ok",
+	statusError: "error",
+}
+
+var resultMarkString = map[resultMark]string{
+	markBullet: "bullet",
+	markTodo:   "todo",
+	markDone:   "done",
+}
+
+func (r *commandResult) addSection(name string, icon resultIcon, items []resultItem, empty string) {
+	section := resultSection{
+		name:  name,
+		icon:  icon,
+		items: items,
+	}
+	if len(items) == 0 {
+		section.text = empty
+	}
+	r.sections = append(r.sections, section)
+}
+
+func (r *commandResult) addTextSection(name, text string) {
+	r.sections = append(r.sections, resultSection{
+		name: name,
+		text: text,
+	})
+}
+
+func bulletItems(lines []string) []resultItem {
+	items := make([]resultItem, len(lines))
+	for i, line := range lines {
+		items[i] = resultItem{mark: markBullet, name: line}
+	}
+	return items
+}
+
+func render(r *commandResult, format renderFormat) string {
+	switch format {
+	case formatJSON:
+		return renderJSON(r)
+	case formatMarkdown:
+		return renderMarkdown(r)
+	}
+	return renderText(r)
+}
+
+// Discord rejects embeds over these limits, counted in characters
+const (
+	embedTitleLimit       = 256
+	embedDescriptionLimit = 4096
+	embedFieldNameLimit   = 256
+	embedFieldValueLimit  = 1024
+	embedFieldsLimit      = 25
+	embedTotalLimit       = 6000
+
+	// Room kept on every page for the "Page 1/2" footer
+	embedFooterReserve = 32
+	// Room kept in field names for the markup and the icon
+	fieldNameReserve = 16
+	itemNameLimit    = 256
+	itemDetailLimit  = 512
+
+	// Value of the fields of empty sections, Discord rejects empty values
+	emptyFieldValue = "—"
+)
+
+// Renders the result as one embed per page. Sections too long for a
+// field continue in the next fields, fields that do not fit in an
+// embed continue on the next page. Pages are only cut between lines
+func renderEmbeds(r *commandResult) []*discordgo.MessageEmbed {
+	newPage := func() *discordgo.MessageEmbed {
+		embed := &discordgo.MessageEmbed{
+			Type:  discordgo.EmbedTypeRich,
+			Title: truncateMarkdown(r.title, embedTitleLimit),
+		}
+		if r.status == statusError {
+			embed.Color = errorColor
+		}
+		return embed
+	}
+
+	page := newPage()
+	page.Description = truncateMarkdown(r.description, embedDescriptionLimit)
+	pages := []*discordgo.MessageEmbed{page}
+	size := runeCount(page.Title) + runeCount(page.Description) + embedFooterReserve
+	for _, section := range r.sections {
+		for i, value := range embedSectionValues(section) {
+			field := &discordgo.MessageEmbedField{
+				Name:  embedFieldName(section, i > 0),
+				Value: value,
+			}
+			fieldSize := runeCount(field.Name) + runeCount(field.Value)
+			if len(page.Fields) > 0 && (len(page.Fields) == embedFieldsLimit || size+fieldSize > embedTotalLimit) {
+				page = newPage()
+				pages = append(pages, page)
+				size = runeCount(page.Title) + embedFooterReserve
+			}
+			page.Fields = append(page.Fields, field)
+			size += fieldSize
+		}
+	}
+
+	if len(pages) > 1 {
+		for i, page := range pages {
+			page.Footer = &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Page %d/%d", i+1, len(pages)),
+			}
+		}
+	}
+	return pages
+}
+
+func embedFieldName(section resultSection, continued bool) string {
+	name := section.name
+	if continued {
+		name += " (cont.)"
+	}
+	name = fmt.Sprintf("**%s:**", truncateMarkdown(name, embedFieldNameLimit-fieldNameReserve))
+	switch section.icon {
+	case iconReminders:
+		name = bellEmote + " " + name
+	case iconTasks:
+		name = todoEmote + " " + name
+	}
+	return name
+}
+
+// Splits the section in field values, between lines
+func embedSectionValues(section resultSection) []string {
+	if len(section.items) == 0 {
+		if strings.TrimSpace(section.text) == "" {
+			return []string{emptyFieldValue}
+		}
+		if runeCount(section.text) <= embedFieldValueLimit {
+			return []string{section.text}
+		}
+		lines := strings.Split(section.text, "\n")
+		for i := range lines {
+			lines[i] = truncateMarkdown(lines[i], embedFieldValueLimit-1) + "\n"
+		}
+		return packLines(lines, embedFieldValueLimit)
+	}
+
+	lines := make([]string, len(section.items))
+	for i, it := range section.items {
+		lines[i] = embedItemLine(it)
+	}
+	return packLines(lines, embedFieldValueLimit)
+}
+
+func embedItemLine(it resultItem) string {
+	b := strings.Builder{}
+	switch it.mark {
+	case markBullet:
+		b.WriteString(":small_orange_diamond:")
+	case markTodo:
+		b.WriteString(todoUncheckEmote)
+	case markDone:
+		b.WriteString(todoCheckEmote)
+	}
+	b.WriteString(" ")
+	if it.highlight {
+		b.WriteString(":warning: ")
+	}
+	b.WriteString("**")
+	b.WriteString(truncateMarkdown(it.name, itemNameLimit))
+	b.WriteString("**")
+	if it.detail != "" {
+		b.WriteString("  ||  ")
+		b.WriteString(truncateMarkdown(it.detail, itemDetailLimit))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Joins the lines in as few chunks of at most limit characters as possible
+func packLines(lines []string, limit int) []string {
+	var chunks []string
+	b := strings.Builder{}
+	size := 0
+	for _, line := range lines {
+		lineSize := runeCount(line)
+		if size > 0 && size+lineSize > limit {
+			chunks = append(chunks, b.String())
+			b.Reset()
+			size = 0
+		}
+		b.WriteString(line)
+		size += lineSize
+	}
+	if size > 0 || len(chunks) == 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
+
+// Markers that must come in pairs
+var markdownMarkers = []string{"**", "__", "~~", "||", "`"}
+
+// Shortens the text to at most limit characters, ending with "…".
+// Mentions, emotes and markers are never cut and the markers left
+// open are closed, so the text renders the same up to the cut
+func truncateMarkdown(text string, limit int) string {
+	if runeCount(text) <= limit {
+		return text
+	}
+
+	b := strings.Builder{}
+	size := 0
+	var open []string
+	closing := func(open []string) int {
+		n := 0
+		for _, marker := range open {
+			n += len(marker)
+		}
+		return n
+	}
+	for rest := text; rest != ""; {
+		token := markdownToken(rest)
+		opened := open
+		isMarker := false
+		for _, marker := range markdownMarkers {
+			if token == marker {
+				isMarker = true
+				break
+			}
+		}
+		if isMarker {
+			if len(open) > 0 && open[len(open)-1] == token {
+				opened = open[:len(open)-1]
+			} else {
+				opened = append(append([]string(nil), open...), token)
+			}
+		}
+		if size+runeCount(token)+closing(opened)+1 > limit {
+			break
+		}
+		b.WriteString(token)
+		size += runeCount(token)
+		open = opened
+		rest = rest[len(token):]
+	}
+
+	b.WriteString("…")
+	for i := len(open) - 1; i >= 0; i -= 1 {
+		b.WriteString(open[i])
+	}
+	return b.String()
+}
+
+// The token at the start of the text: a marker, a <mention>,
+// an :emote: or a single character
+func markdownToken(text string) string {
+	for _, marker := range markdownMarkers {
+		if strings.HasPrefix(text, marker) {
+			return marker
+		}
+	}
+	switch text[0] {
+	case '<':
+		if end := strings.IndexByte(text, '>'); end != -1 && !strings.ContainsAny(text[:end], " \n") {
+			return text[:end+1]
+		}
+	case ':':
+		if end := strings.IndexByte(text[1:], ':'); end > 0 {
+			name := text[1 : end+1]
+			valid := true
+			for i := 0; i < len(name); i += 1 {
+				if !(isTagChar(name[i]) || name[i] == '+') {
+					valid = false
+					break
+				}
+			}
+			if valid {
+				return text[:end+2]
+			}
+		}
+	}
+	_, size := utf8.DecodeRuneInString(text)
+	return text[:size]
+}
+
+func runeCount(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+func renderText(r *commandResult) string {
+	b := strings.Builder{}
+	decoration := "=="
+	if r.status == statusError {
+		decoration = "!!"
+	}
+	b.WriteString(decoration)
+	b.WriteString(" ")
+	b.WriteString(r.title)
+	b.WriteString(" ")
+	b.WriteString(decoration)
+	b.WriteString("\n")
+	if r.description != "" {
+		b.WriteString(strings.TrimSpace(r.description))
+		b.WriteString("\n")
+	}
+
+	for _, section := range r.sections {
+		b.WriteString(section.name)
+		b.WriteString(":\n")
+		if len(section.items) == 0 {
+			b.WriteString("  ")
+			b.WriteString(strings.ReplaceAll(strings.TrimSpace(section.text), "\n", "\n  "))
+			b.WriteString("\n")
+			continue
+		}
+		for _, it := range section.items {
+			switch it.mark {
+			case markBullet:
+				b.WriteString("  - ")
+			case markTodo:
+				b.WriteString("  [ ] ")
+			case markDone:
+				b.WriteString("  [x] ")
+			}
+			if it.highlight {
+				b.WriteString("! ")
+			}
+			b.WriteString(it.name)
+			if it.detail != "" {
+				b.WriteString(" (")
+				b.WriteString(it.detail)
+				b.WriteString(")")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func renderMarkdown(r *commandResult) string {
+	b := strings.Builder{}
+	b.WriteString("## ")
+	b.WriteString(r.title)
+	b.WriteString("\n\n")
+	if r.description != "" {
+		b.WriteString(strings.TrimSpace(r.description))
+		b.WriteString("\n\n")
+	}
+
+	for _, section := range r.sections {
+		b.WriteString("### ")
+		b.WriteString(section.name)
+		b.WriteString("\n\n")
+		if len(section.items) == 0 {
+			b.WriteString(strings.TrimSpace(section.text))
+			b.WriteString("\n\n")
+			continue
+		}
+		for _, it := range section.items {
+			switch it.mark {
+			case markBullet:
+				b.WriteString("- ")
+			case markTodo:
+				b.WriteString("- [ ] ")
+			case markDone:
+				b.WriteString("- [x] ")
+			}
+			if it.highlight {
+				b.WriteString("⚠️ ")
+			}
+			b.WriteString("**")
+			b.WriteString(it.name)
+			b.WriteString("**")
+			if it.detail != "" {
+				b.WriteString(" — ")
+				b.WriteString(it.detail)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type (
+	jsonResult struct {
+		Title       string        `json:"title"`
+		Description string        `json:"description,omitempty"`
+		Status      string        `json:"status"`
+		Sections    []jsonSection `json:"sections,omitempty"`
+	}
+
+	jsonSection struct {
+		Name  string     `json:"name"`
+		Text  string     `json:"text,omitempty"`
+		Items []jsonItem `json:"items,omitempty"`
+	}
+
+	jsonItem struct {
+		Mark      string `json:"mark"`
+		Name      string `json:"name"`
+		Detail    string `json:"detail,omitempty"`
+		Highlight bool   `json:"highlight,omitempty"`
+	}
+)
+
+func renderJSON(r *commandResult) string {
+	result := jsonResult{
+		Title:       r.title,
+		Description: r.description,
+		Status:      resultStatusString[r.status],
+	}
+	for _, section := range r.sections {
+		s := jsonSection{
+			Name: section.name,
+			Text: section.text,
+		}
+		for _, it := range section.items {
+			s.Items = append(s.Items, jsonItem{
+				Mark:      resultMarkString[it.mark],
+				Name:      it.name,
+				Detail:    it.detail,
+				Highlight: it.highlight,
+			})
+		}
+		result.Sections = append(result.Sections, s)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Sprintf(`{"status": "error", "title": %q}`, err.Error())
+	}
+	return string(data) + "\n"
+}
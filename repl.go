@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	osuser "os/user"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/genjidb/genji"
+)
+
+const (
+	replChannelID = "repl"
+	replUserID    = "repl"
+	replPrompt    = "> "
+)
+
+// Drives the command engine from the standard input against the
+// local database. Notifications are printed as they fire
+func runREPL(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	formatStr := fs.String("format", "text", "the output format, one of text, json or markdown")
+	flags := newConfigFlags(fs)
+	fs.Parse(args)
+	flags.collect(fs)
+	format, exist := renderFormats[*formatStr]
+	if !exist {
+		log.Fatalf("Unknown output format: %s", *formatStr)
+	}
+
+	config, err := loadConfig(flags, false)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := genji.Open(config.app.Database)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer db.Close()
+
+	a := &app{
+		s:               &terminalMessenger{out: os.Stdout, format: format},
+		remindChannelID: replChannelID,
+		db:              db,
+		shouldClose:     make(chan bool),
+		outboxWake:      make(chan bool, 1),
+		users:           make(map[string]*user),
+		clock:           realClock{},
+		config:          config.app,
+		configPath:      config.configPath,
+		configFlags:     flags,
+	}
+	a.init()
+	stopConfig := make(chan bool)
+	go a.watchConfig(stopConfig)
+	stopOutbox := make(chan bool)
+	go a.runOutbox(stopOutbox)
+	go a.run()
+
+	author := &discordgo.User{ID: replUserID, Username: replUserID}
+	if current, err := osuser.Current(); err == nil {
+		author.Username = current.Username
+	}
+
+	interactive := false
+	if stat, err := os.Stdin.Stat(); err == nil {
+		interactive = stat.Mode()&os.ModeCharDevice != 0
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Print(replPrompt)
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			break
+		}
+
+		a.handleMessage(&discordgo.MessageCreate{
+			Message: &discordgo.Message{
+				ChannelID: replChannelID,
+				Content:   line,
+				Author:    author,
+			},
+		})
+		// Prints the answer before the next prompt
+		a.deliverOutbox()
+	}
+	a.shouldClose <- true
+	stopOutbox <- true
+	stopConfig <- true
+}
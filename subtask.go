@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+)
+
+func subtaskProgress(task *item) (done, total int) {
+	for _, subtask := range task.subtasks {
+		if subtask.done {
+			done += 1
+		}
+	}
+	return done, len(task.subtasks)
+}
+
+// Finds a task or a subtask by ID, parent is nil for a task
+func findTask(tasks []item, id int) (task *item, parent *item) {
+	for i := range tasks {
+		if tasks[i].id == id {
+			return &tasks[i], nil
+		}
+		if index := findItemByID(tasks[i].subtasks, id); index != -1 {
+			return &tasks[i].subtasks[index], &tasks[i]
+		}
+	}
+	return nil, nil
+}
+
+// Attaches the loaded subtasks to their task, in the order they were added.
+// Subtasks whose task is gone are kept as tasks
+func attachSubtasks(u *user, subtasks []item) {
+	for _, subtask := range subtasks {
+		index := findItemByID(u.tasks, subtask.parentID)
+		if index == -1 {
+			log.Printf("No task %d for subtask %d, keeping it as a task", subtask.parentID, subtask.id)
+			subtask.parentID = 0
+			u.tasks = append(u.tasks, subtask)
+			continue
+		}
+		u.tasks[index].subtasks = append(u.tasks[index].subtasks, subtask)
+	}
+}
+
+// Must run on the state goroutine
+func (a *app) saveTaskDone(u *user, task *item) {
+	err := a.db.Exec("UPDATE items SET done = 1 WHERE id = ?;", task.id)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	a.emitCompletion(u, task)
+	if task.parentID != 0 {
+		a.syncParent(u, task.parentID)
+	}
+}
+
+// A task with subtasks is done once all of them are,
+// and open again when one of them is.
+// Must run on the state goroutine
+func (a *app) syncParent(u *user, parentID int) {
+	index := findItemByID(u.tasks, parentID)
+	if index == -1 || len(u.tasks[index].subtasks) == 0 {
+		return
+	}
+	parent := &u.tasks[index]
+	done, total := subtaskProgress(parent)
+	if (done == total) == parent.done {
+		return
+	}
+
+	parent.done = done == total
+	err := a.db.Exec("UPDATE items SET done = ? WHERE id = ?;", parent.done, parent.id)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+	}
+	if parent.done {
+		a.emitCompletion(u, parent)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/genjidb/genji"
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+type (
+	// A Discord user, role or channel as written in a message
+	mention struct {
+		kind mentionKind
+		id   string
+	}
+
+	mentionKind int
+
+	// Target of a shared item with its own acknowledgement
+	recipient struct {
+		target mention
+		acked  bool
+	}
+)
+
+const (
+	mentionUser mentionKind = iota
+	mentionRole
+	mentionChannel
+)
+
+// The text must be a valid tokenMention
+func makeMention(text string) mention {
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "<"), ">")
+	switch {
+	case strings.HasPrefix(text, "@&"):
+		return mention{kind: mentionRole, id: text[2:]}
+	case strings.HasPrefix(text, "@!"):
+		return mention{kind: mentionUser, id: text[2:]}
+	case strings.HasPrefix(text, "@"):
+		return mention{kind: mentionUser, id: text[1:]}
+	}
+	return mention{kind: mentionChannel, id: text[1:]}
+}
+
+func (m mention) String() string {
+	switch m.kind {
+	case mentionRole:
+		return "<@&" + m.id + ">"
+	case mentionChannel:
+		return "<#" + m.id + ">"
+	}
+	return "<@" + m.id + ">"
+}
+
+// Duplicated targets are dropped
+func makeRecipients(targets []mention) []recipient {
+	var recipients []recipient
+	seen := make(map[mention]bool)
+	for _, target := range targets {
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		recipients = append(recipients, recipient{target: target})
+	}
+	return recipients
+}
+
+func recipientsString(recipients []recipient) string {
+	mentions := make([]string, len(recipients))
+	for i, r := range recipients {
+		mentions[i] = r.target.String()
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// Users and roles are pinged in the remind channel,
+// channels get the alarm posted to them
+func pendingRecipients(recipients []recipient) (mentions, channels []string) {
+	for _, r := range recipients {
+		if r.acked {
+			continue
+		}
+		if r.target.kind == mentionChannel {
+			channels = append(channels, r.target.id)
+		} else {
+			mentions = append(mentions, r.target.String())
+		}
+	}
+	return
+}
+
+// A user acknowledges for themselves, for their roles
+// and for the channel they react in
+func (r recipient) answeredBy(userID, channelID string, roles []string) bool {
+	switch r.target.kind {
+	case mentionUser:
+		return r.target.id == userID
+	case mentionChannel:
+		return r.target.id == channelID
+	}
+	for _, role := range roles {
+		if r.target.id == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *app) initTeam() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS item_recipients (
+		item_id INTEGER NOT NULL,
+		kind INTEGER NOT NULL,
+		target_id TEXT NOT NULL,
+		acked INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	return a.db.Exec(`CREATE TABLE IF NOT EXISTS task_assignments (
+		item_id INTEGER PRIMARY KEY,
+		assigned_by TEXT NOT NULL,
+		accepted INTEGER NOT NULL
+	);`)
+}
+
+// Must be called once the items are loaded
+func (a *app) loadRecipients() error {
+	byItem := make(map[int][]recipient)
+	results, err := a.db.Query("SELECT item_id, kind, target_id, acked FROM item_recipients;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	err = results.Iterate(func(d types.Document) error {
+		var itemID int
+		var kind int
+		var targetID string
+		var acked int
+
+		err := document.Scan(d, &itemID, &kind, &targetID, &acked)
+		byItem[itemID] = append(byItem[itemID], recipient{
+			target: mention{kind: mentionKind(kind), id: targetID},
+			acked:  acked == 1,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range a.users {
+		for i := range u.reminders {
+			u.reminders[i].recipients = byItem[u.reminders[i].id]
+		}
+	}
+	return nil
+}
+
+func insertRecipients(tx *genji.Tx, it *item) error {
+	for _, r := range it.recipients {
+		err := tx.Exec(
+			"INSERT INTO item_recipients (item_id, kind, target_id, acked) VALUES (?, ?, ?, ?);",
+			it.id,
+			r.target.kind,
+			r.target.id,
+			r.acked,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Acknowledges the reminder answered by a ☑ reaction.
+// A reminder that is not shared is removed when its owner acknowledges it,
+// a shared one once every recipient has acknowledged it.
+// Members allowed to acknowledge for others remove either right away.
+// Must run on the state goroutine
+func (a *app) acknowledgeReminder(u *user, id int, userID, channelID string, roles []string, forOthers bool) {
+	index := findItemByID(u.reminders, id)
+	if index == -1 {
+		return
+	}
+	reminder := &u.reminders[index]
+	before := snapshotItem(u, reminder)
+	switch {
+	case forOthers:
+	case len(reminder.recipients) == 0:
+		if userID != u.id {
+			log.Printf("%s may not acknowledge reminder %d of %s", userID, id, u.id)
+			return
+		}
+	default:
+		if !a.ackRecipients(reminder, userID, channelID, roles) {
+			log.Printf("%s is not a recipient of reminder %d", userID, id)
+			return
+		}
+		for _, r := range reminder.recipients {
+			if !r.acked {
+				a.recordChange(userID, changeAcknowledged, before, snapshotItem(u, reminder))
+				return
+			}
+		}
+	}
+	completed := *reminder
+	a.deleteItem(u, completed.id)
+	a.emitCompletion(u, &completed)
+	a.recordChange(userID, changeAcknowledged, before, nil)
+}
+
+// Must run on the state goroutine
+func (a *app) ackRecipients(it *item, userID, channelID string, roles []string) (acked bool) {
+	for i := range it.recipients {
+		r := &it.recipients[i]
+		if r.acked || !r.answeredBy(userID, channelID, roles) {
+			continue
+		}
+		r.acked = true
+		acked = true
+		err := a.db.Exec(
+			"UPDATE item_recipients SET acked = 1 WHERE item_id = ? AND kind = ? AND target_id = ?;",
+			it.id,
+			r.target.kind,
+			r.target.id,
+		)
+		if err != nil {
+			log.Println("DB access failure: ", err)
+		}
+	}
+	return
+}
+
+// Must be called once the items are loaded
+func (a *app) loadAssignments() error {
+	type assignment struct {
+		assignedBy string
+		accepted   bool
+	}
+	byItem := make(map[int]assignment)
+	results, err := a.db.Query("SELECT item_id, assigned_by, accepted FROM task_assignments;")
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	err = results.Iterate(func(d types.Document) error {
+		var itemID int
+		var assignedBy string
+		var accepted int
+
+		err := document.Scan(d, &itemID, &assignedBy, &accepted)
+		byItem[itemID] = assignment{assignedBy: assignedBy, accepted: accepted == 1}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range a.users {
+		for i := range u.tasks {
+			if assigned, exist := byItem[u.tasks[i].id]; exist {
+				u.tasks[i].assignedBy = assigned.assignedBy
+				u.tasks[i].accepted = assigned.accepted
+			}
+		}
+	}
+	return nil
+}
+
+func insertAssignment(tx *genji.Tx, it *item) error {
+	if it.assignedBy == "" {
+		return nil
+	}
+	return tx.Exec(
+		"INSERT INTO task_assignments (item_id, assigned_by, accepted) VALUES (?, ?, ?);",
+		it.id,
+		it.assignedBy,
+		it.accepted,
+	)
+}
+
+// Users ordered by registration, for stable listings
+func sortedUsers(users map[string]*user) []*user {
+	result := make([]*user, 0, len(users))
+	for _, u := range users {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].uniqueID < result[j].uniqueID
+	})
+	return result
+}
+
+// Finds a task by name among the tasks of the user
+// and the tasks they assigned to others
+func findAssignableTask(users map[string]*user, u *user, name string) (owner *user, index int) {
+	if index = findItemByName(u.tasks, name); index != -1 {
+		return u, index
+	}
+	for _, other := range sortedUsers(users) {
+		for i, task := range other.tasks {
+			if task.name == name && task.assignedBy == u.id {
+				return other, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// Moves the task to the list of another user
+func moveTask(from, to *user, id int) *item {
+	index := findItemByID(from.tasks, id)
+	if index == -1 {
+		return nil
+	}
+	task := from.tasks[index]
+	from.tasks = removeItemByID(from.tasks, id)
+	to.tasks = append(to.tasks, task)
+	return &to.tasks[len(to.tasks)-1]
+}
+
+func assignmentDetail(it *item) string {
+	if it.assignedBy == "" {
+		return ""
+	}
+	detail := fmt.Sprintf("from <@%s>", it.assignedBy)
+	if !it.accepted {
+		detail += ", pending"
+	}
+	return detail
+}
+
+// Persists the new assignee of the task and asks them to accept it.
+// Must run on the state goroutine
+func (a *app) saveAssignment(assignee *user, it *item) {
+	err := a.db.Update(func(tx *genji.Tx) error {
+		err := tx.Exec("UPDATE items SET user_id = ? WHERE id = ? OR parent_id = ?;", assignee.uniqueID, it.id, it.id)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM task_assignments WHERE item_id = ?;", it.id)
+		if err != nil {
+			return err
+		}
+		return insertAssignment(tx, it)
+	})
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return
+	}
+	if it.assignedBy != "" {
+		a.notifyAssignment(assignee, it)
+	}
+}
+
+// Must run on the state goroutine
+func (a *app) notifyAssignment(assignee *user, it *item) {
+	a.enqueue(
+		textMessage(a.remindChannelID, fmt.Sprintf("<@%s>", assignee.id)),
+		resultMessage(a.remindChannelID, &commandResult{
+			title: taskAssignment,
+			description: fmt.Sprintf(
+				"<@%s> assigned you **%s**.",
+				it.assignedBy,
+				it.name,
+			),
+		}, acceptEmoji, declineEmoji).about(it.id),
+	)
+}
+
+// An accepted task stays with the assignee,
+// a declined one goes back to the user who assigned it,
+// or becomes a task of the assignee when that user is gone.
+// Must run on the state goroutine
+func (a *app) answerAssignment(u *user, id int, accept bool) {
+	task, _ := findTask(u.tasks, id)
+	if task == nil || task.assignedBy == "" || task.accepted {
+		log.Printf("No pending assignment %d for %s", id, u.id)
+		return
+	}
+	before := snapshotItem(u, task)
+	owner := u
+
+	creator := task.assignedBy
+	answer := "declined"
+	if accept {
+		answer = "accepted"
+		task.accepted = true
+		err := a.db.Exec("UPDATE task_assignments SET accepted = 1 WHERE item_id = ?;", task.id)
+		if err != nil {
+			log.Println("DB access failure: ", err)
+		}
+	} else {
+		if creatorUser, exist := a.users[creator]; exist {
+			owner = creatorUser
+			task = moveTask(u, owner, task.id)
+		}
+		task.assignedBy = ""
+		task.accepted = false
+		a.saveAssignment(owner, task)
+	}
+	a.recordChange(u.id, changeEdited, before, snapshotItem(owner, task))
+
+	a.enqueue(
+		textMessage(a.remindChannelID, fmt.Sprintf("<@%s>", creator)),
+		resultMessage(a.remindChannelID, &commandResult{
+			title:       fmt.Sprintf("Task %s", answer),
+			description: fmt.Sprintf("<@%s> %s **%s**", u.id, answer, task.name),
+		}),
+	)
+}
@@ -15,6 +15,7 @@ func Deserialize(input string, outputFormat interface{}) (err error) {
 	v := reflect.ValueOf(outputFormat).Elem()
 	if !v.IsValid() {
 		err = fmt.Errorf("invalid value %#v", v)
+		return
 	}
 	err = deserializeValue(root, v)
 	return
@@ -23,20 +24,20 @@ func Deserialize(input string, outputFormat interface{}) (err error) {
 func deserializeValue(tomlValue Value, v reflect.Value) (err error) {
 	switch t := tomlValue.(type) {
 	case Number:
-		deserializeNumber(t, v)
+		err = deserializeNumber(t, v)
 
 	case Boolean:
-		deserializeBoolean(t, v)
+		err = deserializeBoolean(t, v)
 
 	case String:
-		deserializeString(t, v)
+		err = deserializeString(t, v)
 
 	case *Array:
 	case Table:
 		err = deserializeTable(t, v)
 	}
 
-	return nil
+	return
 }
 
 func deserializeTable(t Table, v reflect.Value) (err error) {
@@ -52,13 +53,17 @@ func deserializeTable(t Table, v reflect.Value) (err error) {
 		if keyType.Kind() != reflect.String {
 			err = fmt.Errorf("map %#v's key are not of type string", v)
 		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
 		elemType := v.Type().Elem()
 		for key, value := range t {
 			keyValue := reflect.ValueOf(key)
 			elemValue := reflect.New(elemType)
 
-			err = deserializeValue(value, elemValue)
+			err = deserializeValue(value, elemValue.Elem())
 			if err != nil {
+				err = fmt.Errorf("%s: %w", key, err)
 				return
 			}
 
@@ -71,6 +76,7 @@ func deserializeTable(t Table, v reflect.Value) (err error) {
 			if fieldValue.IsValid() {
 				err = deserializeValue(value, fieldValue)
 				if err != nil {
+					err = fmt.Errorf("%s: %w", key, err)
 					return
 				}
 			}
@@ -82,7 +88,7 @@ func deserializeTable(t Table, v reflect.Value) (err error) {
 
 func deserializeNumber(n Number, v reflect.Value) (err error) {
 	if !isNumberValue(v.Kind()) {
-		err = fmt.Errorf("value %v is not a number", v)
+		err = fmt.Errorf("expected a %s, got the number %v", v.Kind(), float64(n))
 		return
 	}
 
@@ -99,7 +105,8 @@ func deserializeNumber(n Number, v reflect.Value) (err error) {
 
 func deserializeBoolean(b Boolean, v reflect.Value) (err error) {
 	if v.Kind() != reflect.Bool {
-		err = fmt.Errorf("value %v is not a bool", v)
+		err = fmt.Errorf("expected a %s, got the boolean %t", v.Kind(), bool(b))
+		return
 	}
 	v.SetBool(bool(b))
 	return
@@ -107,7 +114,8 @@ func deserializeBoolean(b Boolean, v reflect.Value) (err error) {
 
 func deserializeString(s String, v reflect.Value) (err error) {
 	if v.Kind() != reflect.String {
-		err = fmt.Errorf("value %v is not a string", v)
+		err = fmt.Errorf("expected a %s, got the string %q", v.Kind(), string(s))
+		return
 	}
 	v.SetString(string(s))
 	return
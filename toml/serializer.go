@@ -2,6 +2,7 @@ package toml
 
 import (
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -46,7 +47,7 @@ func serializeData(s *serializer, path, name string, v reflect.Value) {
 func serializeStruct(s *serializer, path, name string, v reflect.Value) {
 	t := v.Type()
 
-	currentPath := path + name
+	currentPath := tablePath(path, name)
 	if s.depth > 0 {
 		s.builder.WriteRune('[')
 		s.builder.WriteString(currentPath)
@@ -62,7 +63,7 @@ func serializeStruct(s *serializer, path, name string, v reflect.Value) {
 }
 
 func serializeMap(s *serializer, path, name string, v reflect.Value) {
-	currentPath := path + name
+	currentPath := tablePath(path, name)
 	if s.depth > 0 {
 		s.builder.WriteString("[")
 		s.builder.WriteString(currentPath)
@@ -70,12 +71,13 @@ func serializeMap(s *serializer, path, name string, v reflect.Value) {
 	}
 	s.depth += 1
 
-	i := 0
-	iter := v.MapRange()
-	for iter.Next() {
-		key := iter.Key()
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	for i, key := range keys {
 		keyKind := key.Kind()
-		value := iter.Value()
+		value := v.MapIndex(key)
 
 		var keyName string
 		if keyKind != reflect.String {
@@ -84,10 +86,16 @@ func serializeMap(s *serializer, path, name string, v reflect.Value) {
 			keyName = key.String()
 		}
 		serializeData(s, currentPath, keyName, value)
-		i += 1
 	}
 }
 
+func tablePath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
 // func serializeArray()
 
 func serializeNativeValue(s *serializer, name string, v reflect.Value) {
@@ -177,5 +177,5 @@ func isLetter(c byte) bool {
 }
 
 func isNumber(c byte) bool {
-	return c >= '0' && c < '9'
+	return c >= '0' && c <= '9'
 }
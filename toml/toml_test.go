@@ -246,3 +246,65 @@ func TestDeserializeMap(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDeserializeMapOfStruct(t *testing.T) {
+	input := "[Hooks.ci]\nURL = \"http://localhost:8080/hook\"\n[Hooks.home]\nURL = \"http://home\"\n"
+
+	result := struct {
+		Hooks map[string]struct {
+			URL string
+		}
+	}{}
+
+	err := Deserialize(input, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(result.Hooks) != 2 {
+		t.Fatalf("Expect 2 entries, got %d", len(result.Hooks))
+	}
+	if result.Hooks["ci"].URL != "http://localhost:8080/hook" {
+		t.Errorf("Expect http://localhost:8080/hook, got %s", result.Hooks["ci"].URL)
+	}
+}
+
+func TestSerializeNestedStruct(t *testing.T) {
+	input := struct {
+		X     int
+		Hooks map[string]struct {
+			URL string
+		}
+	}{
+		X: 10,
+		Hooks: map[string]struct {
+			URL string
+		}{
+			"ci": {URL: "http://localhost"},
+		},
+	}
+
+	expect := "X = 10\n[Hooks]\n[Hooks.ci]\nURL = \"http://localhost\"\n"
+
+	result, err := Serialize(&input)
+	if err != nil {
+		t.Error(err)
+	}
+	if result != expect {
+		t.Errorf("Expect %s, got %s", expect, result)
+	}
+}
+
+func TestDeserializeTypeMismatch(t *testing.T) {
+	input := "[Nested]\nX = true\n"
+
+	result := struct {
+		Nested struct {
+			X int
+		}
+	}{}
+
+	err := Deserialize(input, &result)
+	if err == nil {
+		t.Errorf("Expected an error for a boolean in an int field")
+	}
+}
@@ -27,14 +27,14 @@ func makeDate(ddmmyy [3]token, hhmm [2]token) date {
 	if ddmmyy[0].kind == tokenInvalid {
 		result.day = d
 	} else {
-		day, _ := strconv.ParseInt(ddmmyy[0].text, 0, 64)
+		day, _ := strconv.ParseInt(ddmmyy[0].text, 10, 64)
 		result.day = int(day)
 	}
 
 	if ddmmyy[1].kind == tokenInvalid {
 		result.month = m
 	} else {
-		month, _ := strconv.ParseInt(ddmmyy[1].text, 0, 64)
+		month, _ := strconv.ParseInt(ddmmyy[1].text, 10, 64)
 		result.month = time.Month(month)
 	}
 
@@ -42,21 +42,21 @@ func makeDate(ddmmyy [3]token, hhmm [2]token) date {
 		result.year = y
 	} else {
 		yearStr := "20" + ddmmyy[2].text
-		year, _ := strconv.ParseInt(yearStr, 0, 64)
+		year, _ := strconv.ParseInt(yearStr, 10, 64)
 		result.year = int(year)
 	}
 
 	if hhmm[0].kind == tokenInvalid {
 		result.hour = 0
 	} else {
-		hour, _ := strconv.ParseInt(hhmm[0].text, 0, 64)
+		hour, _ := strconv.ParseInt(hhmm[0].text, 10, 64)
 		result.hour = int(hour)
 	}
 
 	if hhmm[1].kind == tokenInvalid {
 		result.min = 0
 	} else {
-		min, _ := strconv.ParseInt(hhmm[1].text, 0, 64)
+		min, _ := strconv.ParseInt(hhmm[1].text, 10, 64)
 		result.min = int(min)
 	}
 	return result
@@ -89,3 +89,21 @@ func findItemByName(buf []item, name string) int {
 	}
 	return -1
 }
+
+func findItemByID(buf []item, id int) int {
+	for i := range buf {
+		if buf[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeItemByID(buf []item, id int) []item {
+	for i := range buf {
+		if buf[i].id == id {
+			return append(buf[:i], buf[i+1:]...)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/genjidb/genji/document"
+	"github.com/genjidb/genji/types"
+)
+
+const (
+	webhookTimeout      = 10 * time.Second
+	webhookBaseBackoff  = 10 * time.Second
+	webhookMaxBackoff   = 1 * time.Hour
+	webhookMaxAttempts  = 10
+	webhookBatchSize    = 20
+	webhookSignatureHdr = "X-RemindMe-Signature"
+	webhookEventHdr     = "X-RemindMe-Event"
+
+	eventItemCreated          = "item_created"
+	eventAlarmFired           = "alarm_fired"
+	eventReminderAcknowledged = "reminder_acknowledged"
+	eventTaskDone             = "task_done"
+	eventReminderMissed       = "reminder_missed"
+)
+
+type (
+	// Events is a comma separated list of the events sent to the URL.
+	// An empty list subscribes to every event
+	webhookConfig struct {
+		URL    string
+		Secret string
+		Events string
+	}
+
+	webhookPayload struct {
+		Event string  `json:"event"`
+		Time  string  `json:"time"`
+		User  apiUser `json:"user"`
+		Item  apiItem `json:"item"`
+	}
+
+	webhookDelivery struct {
+		id       int
+		webhook  string
+		event    string
+		payload  string
+		attempts int
+	}
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+var webhookEvents = map[string]bool{
+	eventItemCreated:          true,
+	eventAlarmFired:           true,
+	eventReminderAcknowledged: true,
+	eventTaskDone:             true,
+	eventReminderMissed:       true,
+}
+
+func (w webhookConfig) isSubscribed(event string) bool {
+	if strings.TrimSpace(w.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(w.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *app) initWebhooks() error {
+	err := a.db.Exec(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY,
+		webhook TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		next_attempt INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	return a.db.Exec("CREATE SEQUENCE IF NOT EXISTS webhook_delivery_seq;")
+}
+
+// Queues the event for every subscribed webhook.
+// Must run on the state goroutine
+func (a *app) emitEvent(event string, u *user, it *item) {
+	if len(a.config.Webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event: event,
+		Time:  a.clock.now().Format(time.RFC3339),
+		User: apiUser{
+			ID:        u.id,
+			Name:      u.name,
+			Reminders: len(u.reminders),
+			Tasks:     len(u.tasks),
+		},
+		Item: makeAPIItem(it),
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for name, webhook := range a.config.Webhooks {
+		if !webhook.isSubscribed(event) {
+			continue
+		}
+		err = a.db.Exec(
+			"INSERT INTO webhook_deliveries (id, webhook, event, payload, attempts, next_attempt) VALUES (NEXT VALUE FOR webhook_delivery_seq, ?, ?, ?, 0, ?);",
+			name,
+			event,
+			string(payload),
+			a.clock.now().Unix(),
+		)
+		if err != nil {
+			log.Println("DB access failure: ", err)
+		}
+	}
+}
+
+func (a *app) runWebhooks(stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(sleepTime):
+			a.deliverWebhooks()
+		}
+	}
+}
+
+func (a *app) deliverWebhooks() {
+	// Tables are scanned in primary key order. ORDER BY would sort in a
+	// temporary database, which genji creates racing with other databases
+	// of the process
+	deliveries := make([]webhookDelivery, 0, webhookBatchSize)
+	result, err := a.db.Query(
+		fmt.Sprintf("SELECT id, webhook, event, payload, attempts FROM webhook_deliveries WHERE next_attempt <= ? LIMIT %d;", webhookBatchSize),
+		a.clock.now().Unix(),
+	)
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return
+	}
+	err = result.Iterate(func(d types.Document) error {
+		var delivery webhookDelivery
+		err := document.Scan(d, &delivery.id, &delivery.webhook, &delivery.event, &delivery.payload, &delivery.attempts)
+		deliveries = append(deliveries, delivery)
+		return err
+	})
+	result.Close()
+	if err != nil {
+		log.Println("DB access failure: ", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		var webhook webhookConfig
+		var exist bool
+		a.do(func() { webhook, exist = a.config.Webhooks[delivery.webhook] })
+
+		if exist {
+			err = sendWebhook(webhook, delivery)
+		} else {
+			err = fmt.Errorf("webhook %s is not configured anymore", delivery.webhook)
+			delivery.attempts = webhookMaxAttempts
+		}
+
+		if err == nil {
+			err = a.db.Exec("DELETE FROM webhook_deliveries WHERE id = ?;", delivery.id)
+		} else {
+			delivery.attempts += 1
+			if delivery.attempts >= webhookMaxAttempts {
+				log.Printf("Dropping %s delivery to webhook %s: %v", delivery.event, delivery.webhook, err)
+				err = a.db.Exec("DELETE FROM webhook_deliveries WHERE id = ?;", delivery.id)
+			} else {
+				next := a.clock.now().Add(webhookBackoff(delivery.attempts))
+				err = a.db.Exec(
+					"UPDATE webhook_deliveries SET attempts = ?, next_attempt = ? WHERE id = ?;",
+					delivery.attempts,
+					next.Unix(),
+					delivery.id,
+				)
+			}
+		}
+		if err != nil {
+			log.Println("DB access failure: ", err)
+		}
+	}
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff << (attempts - 1)
+	if backoff > webhookMaxBackoff || backoff <= 0 {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+func sendWebhook(webhook webhookConfig, delivery webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, strings.NewReader(delivery.payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHdr, delivery.event)
+	if webhook.Secret != "" {
+		req.Header.Set(webhookSignatureHdr, "sha256="+signWebhookPayload(webhook.Secret, []byte(delivery.payload)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}